@@ -0,0 +1,113 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/jdub/cfn-init-tools/runner"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	olderThan time.Duration
+	pruneAll  bool
+)
+
+// cacheCmd represents the cache command
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the cache of downloaded files and sources",
+	//Long:  `...`,
+}
+
+// cacheLsCmd represents the cache ls command
+var cacheLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List cached downloads",
+	//Long:  `...`,
+	Args: cobra.NoArgs,
+	RunE: cfnCacheLs,
+}
+
+// cachePruneCmd represents the cache prune command
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove cached downloads which haven't been used recently, and old run reports and backups",
+	//Long:  `...`,
+	Args: cobra.NoArgs,
+	RunE: cfnCachePrune,
+}
+
+func init() {
+	RootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheLsCmd)
+	cacheCmd.AddCommand(cachePruneCmd)
+
+	cachePruneCmd.Flags().DurationVar(&olderThan, "older-than", 30*24*time.Hour, "Remove downloads last used, and reports and backups written, longer ago than this")
+	cachePruneCmd.Flags().BoolVar(&pruneAll, "all", false, "Remove all cached downloads, reports and backups")
+}
+
+func cfnCacheLs(cmd *cobra.Command, args []string) error {
+	downloads, err := runner.Downloads(Config.DataDir)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "URL\tSIZE\tSHA256\tFETCHED\tUSED")
+	for _, d := range downloads {
+		fmt.Fprintf(w, "%v\t%v\t%.12v\t%v\t%v\n", d.Url, d.Size, d.Sha256, d.Fetched.Format(time.RFC3339), d.Used.Format(time.RFC3339))
+	}
+
+	return w.Flush()
+}
+
+func cfnCachePrune(cmd *cobra.Command, args []string) error {
+	before := time.Now().Add(-olderThan)
+	if pruneAll {
+		before = time.Now().Add(time.Hour)
+	}
+
+	removed, err := runner.PruneDownloads(Config.DataDir, before)
+	for _, url := range removed {
+		fmt.Println("Removed", url)
+	}
+	if err != nil {
+		return err
+	}
+
+	reports, err := runner.PruneReports(Config.DataDir, before)
+	for _, runId := range reports {
+		fmt.Println("Removed report", runId)
+	}
+	if err != nil {
+		return err
+	}
+
+	backups, err := runner.PruneBackups(Config.DataDir, before)
+	for _, runId := range backups {
+		fmt.Println("Removed backups of run", runId)
+	}
+	return err
+}
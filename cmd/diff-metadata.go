@@ -0,0 +1,87 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/jdub/cfn-init-tools/metadata"
+	"github.com/spf13/cobra"
+)
+
+// diffMetadataCmd represents the diff-metadata command
+var diffMetadataCmd = &cobra.Command{
+	Use:   "diff-metadata OLD [NEW]",
+	Short: "Compare two metadata documents, or one with the stack resource",
+	Long: `Compare two metadata documents, or one with the stack resource
+
+Reports the configSets, configs, and packages, groups, users, sources, files,
+commands and services within them that were added (+), removed (-) or
+changed (~). Without NEW, OLD is compared with the metadata fetched using the
+global options, e.g. --stack and --resource.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: cfnDiffMetadata,
+}
+
+func init() {
+	RootCmd.AddCommand(diffMetadataCmd)
+}
+
+func cfnDiffMetadata(cmd *cobra.Command, args []string) error {
+	old, err := parseMetadata(args[0])
+	if err != nil {
+		return err
+	}
+
+	name := ""
+	if len(args) == 2 {
+		name = args[1]
+	}
+	current, err := parseMetadata(name)
+	if err != nil {
+		return err
+	}
+
+	for _, change := range metadata.Diff(old, current) {
+		fmt.Println(change)
+	}
+
+	return nil
+}
+
+// Fetches and parses the named local document, or with no name, the metadata
+// from the configured source
+func parseMetadata(name string) (metadata.Metadata, error) {
+	conf := Config
+	if name != "" {
+		conf.Local, conf.Source = []string{name}, ""
+	}
+
+	raw, err := metadata.Fetch(conf)
+	if err != nil {
+		return metadata.Metadata{}, exit(ExitFetch, err)
+	}
+
+	m, err := metadata.Parse(raw)
+	if err != nil && name != "" {
+		err = fmt.Errorf("%v: %v", name, err)
+	}
+	return m, exit(ExitParse, err)
+}
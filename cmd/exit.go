@@ -0,0 +1,107 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"errors"
+	"github.com/jdub/cfn-init-tools/runner"
+)
+
+// Exit codes distinguish classes of failure, so wrappers can decide whether
+// to retry, and the reason can be passed on with a signal. They are stable:
+// add new codes, but never renumber existing ones.
+const (
+	ExitOK       = 0
+	ExitError    = 1  // Any other error, including invalid options
+	ExitFetch    = 10 // The metadata could not be fetched
+	ExitParse    = 11 // The metadata could not be parsed or is invalid
+	ExitPackages = 20 // A package could not be installed
+	ExitUsers    = 21 // A group or user could not be created
+	ExitFiles    = 22 // A source or file could not be written
+	ExitCommands = 23 // A command failed
+	ExitServices = 24 // A service could not be configured
+	ExitSignal   = 30 // A signal could not be sent
+)
+
+const exitCodesHelp = `Exit codes:
+  0   success
+  1   any other error, including invalid options
+  10  the metadata could not be fetched
+  11  the metadata could not be parsed or is invalid
+  20  a package could not be installed
+  21  a group or user could not be created
+  22  a source or file could not be written
+  23  a command failed
+  24  a service could not be configured
+  30  a signal could not be sent`
+
+// An error with a specific exit code
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+func exit(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code, err}
+}
+
+// Exit codes for the sections of a config
+var sectionExitCodes = map[string]int{
+	"packages": ExitPackages,
+	"groups":   ExitUsers,
+	"users":    ExitUsers,
+	"sources":  ExitFiles,
+	"files":    ExitFiles,
+	"commands": ExitCommands,
+	"services": ExitServices,
+}
+
+// ExitCode returns the exit code for an error returned by RootCmd.Execute
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+
+	var s *runner.SectionError
+	if errors.As(err, &s) {
+		if code, ok := sectionExitCodes[s.Section]; ok {
+			return code
+		}
+	}
+
+	return ExitError
+}
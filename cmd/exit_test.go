@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/jdub/cfn-init-tools/runner"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{nil, ExitOK},
+		{fmt.Errorf("pants"), ExitError},
+		{exit(ExitFetch, fmt.Errorf("pants")), ExitFetch},
+		{&runner.SectionError{Config: "config", Section: "commands", Err: fmt.Errorf("pants")}, ExitCommands},
+		{&runner.SectionError{Config: "config", Section: "users", Err: fmt.Errorf("pants")}, ExitUsers},
+		{&runner.SectionError{Config: "config", Section: "pants", Err: fmt.Errorf("pants")}, ExitError},
+	}
+	for _, test := range tests {
+		if code := ExitCode(test.err); code != test.code {
+			t.Errorf("%v: %v != %v", test.err, code, test.code)
+		}
+	}
+
+	if exit(ExitParse, nil) != nil {
+		t.Error("exit should not wrap a nil error")
+	}
+}
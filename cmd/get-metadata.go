@@ -24,10 +24,13 @@ import (
 	"fmt"
 	"github.com/jdub/cfn-init-tools/metadata"
 	"github.com/spf13/cobra"
+	"strings"
 )
 
 var (
-	key string
+	key    string
+	merged bool
+	output string
 )
 
 // getMetadataCmd represents the get-metadata command
@@ -42,24 +45,27 @@ func init() {
 	RootCmd.AddCommand(getMetadataCmd)
 
 	getMetadataCmd.Flags().StringVarP(&key, "key", "k", "", "Retrieve the value at <key> in the Metadata object; must be in dotted object notation (parent.child.leaf)")
+	getMetadataCmd.Flags().DurationVar(&Config.MaxAge, "max-age", 0, "Use cached metadata if it was fetched within this duration (e.g. 5m). Keeps a private, unredacted copy in the data directory")
+	getMetadataCmd.Flags().BoolVar(&merged, "merged", false, "Show the result of merging multiple --local documents")
+	getMetadataCmd.Flags().StringVarP(&output, "output", "o", "json", "Output format: "+strings.Join(metadata.Formats, ", "))
 }
 
 func cfnGetMetadata(cmd *cobra.Command, args []string) error {
-	if Config.Local == "" && (Config.Stack == "" || Config.Resource == "") {
-		return fmt.Errorf("You must pass --local, or --stack and --resource")
+	if len(Config.Local) > 1 && !merged {
+		return fmt.Errorf("You must pass --merged to view multiple --local documents")
 	}
 
 	raw, err := metadata.Fetch(Config)
 	if err != nil {
-		return err
+		return exit(ExitFetch, err)
 	}
 
-	json, err := metadata.Json(raw, key)
+	out, err := metadata.Output(raw, key, output)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println(json)
+	fmt.Println(out)
 
 	return nil
 }
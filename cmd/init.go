@@ -21,21 +21,27 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"github.com/davecgh/go-spew/spew"
+	"github.com/jdub/cfn-init-tools/config"
 	"github.com/jdub/cfn-init-tools/metadata"
+	"github.com/jdub/cfn-init-tools/runner"
 	"github.com/spf13/cobra"
-	"io/ioutil"
+	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
-	"strings"
+	"syscall"
 )
 
 var (
-	configSets []string
-	resume     bool
-	verbose    bool
+	configSets  []string
+	keepSecrets bool
+	report      string
+	resume      bool
+	verbose     bool
 )
 
 // initCmd represents the init command
@@ -49,10 +55,15 @@ var initCmd = &cobra.Command{
 func init() {
 	RootCmd.AddCommand(initCmd)
 
-	s := initCmd.Flags().StringP("configsets", "c", "default", "An optional list of configSets")
-	configSets = strings.Split(*s, ",")
+	initCmd.Flags().StringSliceVarP(&configSets, "configsets", "c", []string{"default"}, "An optional list of configSets")
 
 	initCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Enables verbose logging")
+	initCmd.Flags().BoolVar(&keepSecrets, "keep-secrets", false, "Also write an unredacted copy of the metadata, readable only by its owner")
+	initCmd.Flags().BoolVar(&Config.ParallelPackages, "parallel-packages", false, "Run package managers that don't share a database (e.g. apt, python and rubygems) concurrently. Only use this if their packages are independent!")
+	initCmd.Flags().IntVar(&Config.PackageWorkers, "package-workers", runner.DefaultPackageWorkers, "How many package managers may run at once with --parallel-packages")
+	initCmd.Flags().IntVar(&Config.DownloadWorkers, "download-workers", runner.DefaultDownloadWorkers, "How many files and sources may be downloaded at once")
+	initCmd.Flags().DurationVar(&Config.CommandTimeout, "command-timeout", 0, "Kill commands, and their children, which run for longer than this (e.g. 10m). A command's timeout overrides it")
+	initCmd.Flags().StringVar(&report, "report", "", "Also print the run report to stdout, in this format: json")
 
 	if runtime.GOOS == "windows" {
 		initCmd.Flags().BoolVar(&resume, "resume", false, "Resume from a previous cfn-init run")
@@ -60,39 +71,114 @@ func init() {
 }
 
 func cfnInit(cmd *cobra.Command, args []string) error {
-	if Config.Local == "" && (Config.Stack == "" || Config.Resource == "") {
-		return fmt.Errorf("You must pass --local, or --stack and --resource")
+	if report != "" && report != "json" {
+		return fmt.Errorf("Unknown report format %q", report)
 	}
 
-	raw, err := metadata.Fetch(Config)
-	if err != nil {
+	// Prepare the data directory for logging and whatnot
+	if err := config.PrepareDataDir(Config.DataDir); err != nil {
 		return err
 	}
 
-	meta, err := metadata.Parse(raw)
+	logFile, err := os.OpenFile(filepath.Join(Config.DataDir, "cfn-init.log"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
 	if err != nil {
 		return err
 	}
+	defer logFile.Close()
 
-	// Prepare the data directory for logging and whatnot
-	if err := os.MkdirAll(Config.DataDir, 0644); err != nil {
-		//fmt.Fprintf(os.Stderr, "Error: Could not create data directory: %v\n", Config.DataDir)
+	var w io.Writer = logFile
+	if verbose {
+		w = io.MultiWriter(logFile, os.Stderr)
+	}
+	r := runner.New(Config, w)
+
+	err = run(r)
+
+	// Every run is reported, whether or not it got as far as running configs
+	r.Report.Finish(err)
+	if werr := r.Report.Write(Config.DataDir); werr != nil && err == nil {
+		err = werr
+	}
+	if report == "json" {
+		j, jerr := r.Report.Json()
+		if jerr != nil && err == nil {
+			err = jerr
+		}
+		fmt.Println(j)
+	}
+
+	return err
+}
+
+func run(r *runner.Runner) error {
+	raw, err := metadata.Fetch(Config)
+	if err != nil {
+		return exit(ExitFetch, err)
+	}
+
+	m, err := metadata.Parse(raw)
+	if err != nil {
+		return exit(ExitParse, err)
+	}
+
+	// Secrets must not reach logs or the persisted copy of the metadata
+	redacted, err := metadata.Redact(raw, Config.Redact)
+	if err != nil {
 		return err
 	}
 
 	// Write fetched metadata to file
+	if err := writeMetadata("metadata.json", redacted); err != nil {
+		return err
+	}
+
+	unredacted := "metadata.unredacted.json"
+	if keepSecrets {
+		if err := writeMetadata(unredacted, raw); err != nil {
+			return err
+		}
+	} else if err := os.Remove(filepath.Join(Config.DataDir, unredacted)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if verbose {
+		safe, err := metadata.Parse(redacted)
+		if err != nil {
+			return err
+		}
+		spew.Fdump(os.Stderr, safe)
+	}
+
+	// Anything but a failing section means the configSets were invalid
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = r.Run(ctx, m, configSets)
+	if _, ok := err.(*runner.SectionError); !ok {
+		return exit(ExitParse, err)
+	}
+	return err
+}
+
+// Writes metadata to a file in the data directory, readable only by its owner
+func writeMetadata(name string, raw string) error {
 	json, err := metadata.Json(raw, "")
 	if err != nil {
 		return err
 	}
 
-	name := filepath.Join(Config.DataDir, "metadata.json")
-	if err := ioutil.WriteFile(name, []byte(json), os.FileMode(0644)); err != nil {
-		//fmt.Fprintf(os.Stderr, "Error: Failed to write %v\n", name)
+	name = filepath.Join(Config.DataDir, name)
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
 		return err
 	}
+	defer f.Close()
 
-	spew.Dump(meta)
+	// Existing files may have been created with a looser mode
+	if err := f.Chmod(0600); err != nil {
+		return err
+	}
 
-	return nil
+	_, err = f.WriteString(json)
+	return err
 }
@@ -0,0 +1,71 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/jdub/cfn-init-tools/runner"
+	"github.com/spf13/cobra"
+)
+
+var listBackups bool
+
+// rollbackCmd represents the rollback command
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [RUN-ID]",
+	Short: "Restore the files changed by an init run",
+	Long: `Restore the files changed by an init run
+
+Every file the init command creates or changes is first backed up in the data
+directory. This restores the content, mode and ownership of the files changed
+by the given run, or by default the latest run, and removes those it created.
+Run IDs are listed with --list, and match the names of run reports. Old
+backups are removed by cache prune.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: cfnRollback,
+}
+
+func init() {
+	RootCmd.AddCommand(rollbackCmd)
+
+	rollbackCmd.Flags().BoolVar(&listBackups, "list", false, "List the runs with backups, oldest first")
+}
+
+func cfnRollback(cmd *cobra.Command, args []string) error {
+	if listBackups {
+		runs, err := runner.Backups(Config.DataDir)
+		for _, run := range runs {
+			fmt.Println(run)
+		}
+		return err
+	}
+
+	runId := ""
+	if len(args) == 1 {
+		runId = args[0]
+	}
+
+	runId, restored, err := runner.Rollback(Config.DataDir, runId)
+	for _, path := range restored {
+		fmt.Printf("Restored %v from run %v\n", path, runId)
+	}
+	return err
+}
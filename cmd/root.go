@@ -21,40 +21,145 @@
 package cmd
 
 import (
+	"fmt"
 	"github.com/jdub/cfn-init-tools/config"
+	"github.com/jdub/cfn-init-tools/metadata"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"os"
 	"runtime"
+	"time"
 )
 
 var (
-	Config config.Config
+	Config     config.Config
+	ConfigFile string
+
+	// Where the effective value of each global option came from
+	origins = make(map[string]string)
 )
 
 var RootCmd = &cobra.Command{
 	Use:   "cfn",
 	Short: "A suite of host automation utilities for CloudFormation",
+	Long: `A suite of host automation utilities for CloudFormation
+
+Global options are read from the configuration file (default /etc/cfn/cfn.conf),
+then CFN_* environment variables (e.g. CFN_STACK, CFN_HTTP_PROXY), and finally
+from flags, each overriding the last.
+
+` + exitCodesHelp,
+	PersistentPreRunE: loadConfig,
+	// Errors are reported by main, along with the exit code
+	SilenceErrors: true,
 }
 
 func init() {
-	RootCmd.PersistentFlags().StringVar(&Config.Local, "local", "", "Local metadata JSON file")
+	if runtime.GOOS == "windows" {
+		ConfigFile = os.ExpandEnv(`${SystemDrive}\cfn\cfn.conf`)
+	} else {
+		ConfigFile = "/etc/cfn/cfn.conf"
+	}
+	RootCmd.PersistentFlags().StringVar(&ConfigFile, "config", ConfigFile, "A configuration file providing global options")
+
+	RootCmd.PersistentFlags().StringVar(&Config.Source, "source", "", "A metadata source URL: file://, http(s)://, s3://bucket/key, imds://user-data, or - for stdin")
+	RootCmd.PersistentFlags().StringArrayVar(&Config.Local, "local", nil, "Local metadata or template (with --resource) JSON or YAML file, or - for stdin. Repeat to merge multiple documents, later ones taking precedence")
+	RootCmd.PersistentFlags().StringVar(&Config.MergeConfigSets, "merge-configsets", metadata.MergeReplace, "How configSets in multiple --local documents are merged: "+metadata.MergeReplace+" or "+metadata.MergeConcat)
+	RootCmd.PersistentFlags().BoolVar(&Config.TolerantYaml, "tolerant-yaml", false, "Accept CloudFormation short-form tags (!Ref, !Sub, ...) in local YAML files")
+	RootCmd.PersistentFlags().StringVar(&Config.Parameters, "parameters", "", "A parameters file used to resolve intrinsic functions in local metadata")
+	RootCmd.PersistentFlags().StringVar(&Config.AccountId, "account-id", "", "The AWS account ID used for AWS::AccountId when resolving intrinsic functions in local metadata")
 
 	RootCmd.PersistentFlags().StringVarP(&Config.Stack, "stack", "s", "", "A CloudFormation stack")
 	RootCmd.PersistentFlags().StringVarP(&Config.Resource, "resource", "r", "", "A CloudFormation logical resource ID")
 	RootCmd.PersistentFlags().StringVar(&Config.Region, "region", "us-east-1", "The CloudFormation region")
 	RootCmd.PersistentFlags().StringVarP(&Config.Url, "url", "u", "", "The CloudFormation service URL. The endpoint URL must match the region option. Use of this parameter is discouraged.")
 
-	RootCmd.PersistentFlags().StringVarP(&Config.CredFile, "credential-file", "f", "", "OBSOLETE: Use a standard credentials file and/or AWS_PROFILE environment variable")
-	RootCmd.PersistentFlags().StringVar(&Config.Role, "role", "", "OBSOLETE: IAM Role credentials will be used automatically")
-	RootCmd.PersistentFlags().StringVar(&Config.AccessKey, "access-key", "", "OBSOLETE: Use a standard credentials file or AWS_ACCESS_KEY_ID environment variable")
-	RootCmd.PersistentFlags().StringVar(&Config.SecretKey, "secret-key", "", "OBSOLETE: Use a standard credentials file or AWS_SECRET_ACCESS_KEY environment variable")
+	RootCmd.PersistentFlags().StringVarP(&Config.CredFile, "credential-file", "f", "", "OBSOLETE, but honoured: Use a standard credentials file and/or AWS_PROFILE environment variable")
+	RootCmd.PersistentFlags().StringVar(&Config.Role, "role", "", "OBSOLETE, but honoured: IAM Role credentials will be used automatically")
+	RootCmd.PersistentFlags().StringVar(&Config.AccessKey, "access-key", "", "OBSOLETE, but honoured: Use a standard credentials file or AWS_ACCESS_KEY_ID environment variable")
+	RootCmd.PersistentFlags().StringVar(&Config.SecretKey, "secret-key", "", "OBSOLETE, but honoured: Use a standard credentials file or AWS_SECRET_ACCESS_KEY environment variable")
+
+	RootCmd.PersistentFlags().StringVar(&Config.AssumeRoleArn, "assume-role-arn", "", "An IAM role to assume, e.g. in another account, for fetching metadata")
+	RootCmd.PersistentFlags().StringVar(&Config.ExternalId, "external-id", "", "The external ID required to assume the role")
+	RootCmd.PersistentFlags().StringVar(&Config.WebIdentityTokenFile, "web-identity-token-file", "", "Assume the role with the OIDC token in this file, rather than existing credentials")
+	RootCmd.PersistentFlags().StringVar(&Config.StsUrl, "sts-url", "", "The STS service URL used to assume the role")
+
+	RootCmd.PersistentFlags().StringSliceVar(&Config.Redact, "redact", nil, "Additional metadata keys to mask in logs and persisted copies, as case-insensitive globs (e.g. *token*)")
+	RootCmd.PersistentFlags().BoolVar(&Config.Cached, "cached", false, "Use the last fetched metadata if CloudFormation is unreachable (keeps a private, unredacted copy in the data directory)")
 
 	RootCmd.PersistentFlags().StringVar(&Config.HttpProxy, "http-proxy", "", "A (non-SSL) HTTP proxy")
 	RootCmd.PersistentFlags().StringVar(&Config.HttpsProxy, "https-proxy", "", "An HTTPS proxy")
 
+	RootCmd.PersistentFlags().StringVar(&Config.CaBundle, "ca-bundle", "", "A PEM file of additional CA certificates to trust, e.g. for a private endpoint")
+	RootCmd.PersistentFlags().StringVar(&Config.ClientCert, "client-cert", "", "A PEM client certificate to present to endpoints")
+	RootCmd.PersistentFlags().StringVar(&Config.ClientKey, "client-key", "", "The PEM private key for --client-cert")
+	RootCmd.PersistentFlags().BoolVar(&Config.InsecureSkipVerify, "insecure-skip-verify", false, "Do not verify TLS certificates. For local testing only!")
+	RootCmd.PersistentFlags().DurationVar(&Config.HttpTimeout, "http-timeout", 2*time.Minute, "Give up on an HTTP request when no data has been received for this long. 0 for no limit")
+
+	dataDir := "/var/lib/cfn-init/data"
 	if runtime.GOOS == "windows" {
-		Config.DataDir = os.ExpandEnv(`${SystemDrive}\cfn\cfn-init\data`)
-	} else {
-		Config.DataDir = "/var/lib/cfn-init/data"
+		dataDir = os.ExpandEnv(`${SystemDrive}\cfn\cfn-init\data`)
 	}
+	RootCmd.PersistentFlags().StringVar(&Config.DataDir, "data-dir", dataDir, "A directory for persistent state, such as fetched metadata and logs")
+}
+
+// Layers global options: defaults, then the configuration file, then CFN_*
+// environment variables, then flags
+func loadConfig(cmd *cobra.Command, args []string) error {
+	flags := cmd.Root().PersistentFlags()
+
+	explicit, configOrigin := flags.Changed("config"), "default"
+	if explicit {
+		configOrigin = "flag"
+	} else if env, ok := os.LookupEnv(config.EnvVar("config")); ok {
+		ConfigFile, explicit, configOrigin = env, true, config.EnvVar("config")
+	}
+
+	// Only a missing default configuration file is acceptable
+	file, err := config.ReadFile(ConfigFile)
+	if err != nil {
+		if explicit || !os.IsNotExist(err) {
+			return err
+		}
+		err = nil
+	}
+	for name := range file {
+		if name == "config" || flags.Lookup(name) == nil {
+			return fmt.Errorf("%v: unknown option %q", ConfigFile, name)
+		}
+	}
+
+	env := config.Environ(os.Environ())
+
+	// FlagSet.Set, unlike Value.Set, marks the flag as Changed for commands
+	// that check whether an option was given
+	flags.VisitAll(func(f *pflag.Flag) {
+		if err != nil {
+			return
+		}
+
+		switch value, inEnv := env[f.Name]; {
+		case f.Name == "config":
+			origins[f.Name] = configOrigin
+		case f.Changed:
+			origins[f.Name] = "flag"
+		case inEnv:
+			if err = flags.Set(f.Name, value); err != nil {
+				err = fmt.Errorf("%v: %v", config.EnvVar(f.Name), err)
+			}
+			origins[f.Name] = config.EnvVar(f.Name)
+		case file[f.Name] != "":
+			if err = flags.Set(f.Name, file[f.Name]); err != nil {
+				err = fmt.Errorf("%v: %v: %v", ConfigFile, f.Name, err)
+			}
+			origins[f.Name] = ConfigFile
+		default:
+			origins[f.Name] = "default"
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return config.CheckDataDir(Config.DataDir)
 }
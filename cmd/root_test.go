@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "cfn.conf")
+	if err := ioutil.WriteFile(file, []byte("[main]\nregion=ap-southeast-2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CFN_CONFIG", file)
+	t.Setenv("CFN_STACK", "pants")
+	t.Setenv("CFN_DATA_DIR", filepath.Join(dir, "data"))
+
+	if err := loadConfig(RootCmd, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	flags := RootCmd.PersistentFlags()
+	tests := []struct {
+		name, value, origin string
+		changed             bool
+	}{
+		{"config", file, "CFN_CONFIG", false},
+		{"stack", "pants", "CFN_STACK", true},
+		{"region", "ap-southeast-2", file, true},
+		{"resource", "", "default", false},
+	}
+	for _, test := range tests {
+		f := flags.Lookup(test.name)
+		if v := f.Value.String(); v != test.value {
+			t.Errorf("%v: %q != %q", test.name, v, test.value)
+		}
+		if o := origins[test.name]; o != test.origin {
+			t.Errorf("%v: origin %q != %q", test.name, o, test.origin)
+		}
+		if c := flags.Changed(test.name); c != test.changed {
+			t.Errorf("%v: changed %v != %v", test.name, c, test.changed)
+		}
+	}
+}
+
+func TestLocalFlag(t *testing.T) {
+	defer func() { Config.Local = nil }()
+
+	// File names may contain commas
+	flags := RootCmd.PersistentFlags()
+	for _, name := range []string{"a,b.json", "c.yaml"} {
+		if err := flags.Set("local", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(Config.Local, []string{"a,b.json", "c.yaml"}) {
+		t.Errorf("%q", Config.Local)
+	}
+}
@@ -20,18 +20,53 @@
 
 package config
 
+import (
+	"time"
+)
+
 type Config struct {
-	Local      string
-	Stack      string
-	Resource   string
-	Region     string
-	Url        string
-	HttpProxy  string
-	HttpsProxy string
-	CredFile   string
-	Role       string
-	AccessKey  string
-	SecretKey  string
+	Source          string
+	Local           []string
+	MergeConfigSets string
+	TolerantYaml    bool
+	Parameters      string
+	AccountId       string
+	Stack           string
+	Resource        string
+	Region          string
+	Url             string
+	HttpProxy       string
+	HttpsProxy      string
+	CredFile        string
+	Role            string
+	AccessKey       string
+	SecretKey       string
+
+	AssumeRoleArn        string
+	ExternalId           string
+	WebIdentityTokenFile string
+	StsUrl               string
+
+	CaBundle           string
+	ClientCert         string
+	ClientKey          string
+	InsecureSkipVerify bool
+	HttpTimeout        time.Duration
 
 	DataDir string
+	Redact  []string
+
+	// Metadata cache
+	MaxAge time.Duration
+	Cached bool
+
+	// Package installation
+	ParallelPackages bool
+	PackageWorkers   int
+
+	// Files and sources
+	DownloadWorkers int
+
+	// Commands
+	CommandTimeout time.Duration
 }
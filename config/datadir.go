@@ -0,0 +1,63 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package config
+
+import (
+	"fmt"
+	"os"
+)
+
+// The data directory holds metadata, which may contain secrets
+const DataDirMode = 0700
+
+// PrepareDataDir creates the data directory if necessary, checks that it's
+// safe to use, then fixes its mode (earlier releases created it 0644)
+func PrepareDataDir(dir string) error {
+	if err := os.MkdirAll(dir, DataDirMode); err != nil {
+		return err
+	}
+	if err := CheckDataDir(dir); err != nil {
+		return err
+	}
+	return os.Chmod(dir, DataDirMode)
+}
+
+// CheckDataDir refuses a data directory that others could tamper with: one
+// that is world-writable or owned by another (non-root) user. A directory
+// that doesn't exist yet is fine.
+func CheckDataDir(dir string) error {
+	fi, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if !fi.IsDir() {
+		return fmt.Errorf("Data directory %v is not a directory", dir)
+	}
+
+	if fi.Mode().Perm()&0002 != 0 {
+		return fmt.Errorf("Refusing to use world-writable data directory %v", dir)
+	}
+
+	return checkOwner(dir, fi)
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestDataDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("data directory modes are not checked on Windows")
+	}
+
+	tmp, err := ioutil.TempDir("", "cfn-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	dir := filepath.Join(tmp, "cfn-init", "data")
+	if err := CheckDataDir(dir); err != nil {
+		t.Errorf("a missing data directory should be fine: %v", err)
+	}
+
+	if err := PrepareDataDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(dir); err != nil {
+		t.Error(err)
+	} else if fi.Mode().Perm() != DataDirMode {
+		t.Errorf("%v != %v", fi.Mode().Perm(), os.FileMode(DataDirMode))
+	}
+
+	if err := os.Chmod(dir, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := PrepareDataDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(dir); err != nil {
+		t.Error(err)
+	} else if fi.Mode().Perm() != DataDirMode {
+		t.Errorf("existing data directory mode not fixed: %v", fi.Mode().Perm())
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckDataDir(dir); err == nil {
+		t.Errorf("a world-writable data directory should be refused")
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// The prefix of environment variables that provide global options
+const EnvPrefix = "CFN_"
+
+// ReadFile reads global options from a cfn-hup style configuration file:
+//
+//	[main]
+//	stack=my-stack
+//	region=ap-southeast-2
+//
+// Options may also appear before any section. Other sections are ignored.
+func ReadFile(name string) (map[string]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	opts, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", name, err)
+	}
+	return opts, nil
+}
+
+// Parse reads global options in the configuration file format
+func Parse(r io.Reader) (map[string]string, error) {
+	opts := make(map[string]string)
+	section := "main"
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != "main" {
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("line %d: expected key=value", n)
+		}
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		opts[strings.Replace(key, "_", "-", -1)] = strings.TrimSpace(kv[1])
+	}
+
+	return opts, scanner.Err()
+}
+
+// Environ returns global options from CFN_* environment variables, named
+// like their flags, e.g. CFN_HTTP_PROXY sets --http-proxy
+func Environ(environ []string) map[string]string {
+	opts := make(map[string]string)
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, EnvPrefix) {
+			continue
+		}
+		kv := strings.SplitN(kv[len(EnvPrefix):], "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		opts[strings.Replace(strings.ToLower(kv[0]), "_", "-", -1)] = kv[1]
+	}
+	return opts
+}
+
+// EnvVar converts an option name to its environment variable
+func EnvVar(name string) string {
+	return EnvPrefix + strings.Replace(strings.ToUpper(name), "-", "_", -1)
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	conf := `
+# Baked into the AMI
+stack = tango
+[main]
+region=ap-southeast-2
+HTTP_PROXY = http://proxy:3128
+
+[hooks]
+resource = ignored
+`
+	opts, err := Parse(strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"stack":      "tango",
+		"region":     "ap-southeast-2",
+		"http-proxy": "http://proxy:3128",
+	}
+	if len(opts) != len(want) {
+		t.Errorf("%v != %v", opts, want)
+	}
+	for k, v := range want {
+		if opts[k] != v {
+			t.Errorf("%v: %q != %q", k, opts[k], v)
+		}
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	if _, err := Parse(strings.NewReader("pants")); err == nil {
+		t.Errorf("lines without = should be an error")
+	}
+}
+
+func TestEnviron(t *testing.T) {
+	opts := Environ([]string{"CFN_STACK=tango", "CFN_HTTPS_PROXY=http://proxy:3128", "HOME=/root", "CFN_=pants"})
+
+	if len(opts) != 2 || opts["stack"] != "tango" || opts["https-proxy"] != "http://proxy:3128" {
+		t.Errorf("unexpected options: %v", opts)
+	}
+
+	if v := EnvVar("https-proxy"); v != "CFN_HTTPS_PROXY" {
+		t.Errorf("%v != CFN_HTTPS_PROXY", v)
+	}
+}
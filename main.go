@@ -33,7 +33,7 @@ func main() {
 	exe := os.Args[0]
 	exe = strings.TrimSuffix(filepath.Base(exe), filepath.Ext(exe))
 	sub := strings.SplitN(exe, "-", 2)
-	if sub[0] == "cfn" {
+	if len(sub) == 2 && sub[0] == "cfn" {
 		if sub[1] == "init-tools" { // cfn-init-tools{,.exe}
 			sub = sub[:1]
 		}
@@ -42,7 +42,7 @@ func main() {
 	}
 
 	if err := cmd.RootCmd.Execute(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(cmd.ExitCode(err))
 	}
 }
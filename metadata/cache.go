@@ -0,0 +1,104 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metadata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/jdub/cfn-init-tools/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The last metadata fetched for a stack resource
+type CacheEntry struct {
+	Stack    string    `json:"stack"`
+	Resource string    `json:"resource"`
+	Region   string    `json:"region"`
+	Hash     string    `json:"hash"`
+	Fetched  time.Time `json:"fetched"`
+	Modified time.Time `json:"modified"`
+	Metadata string    `json:"metadata"`
+}
+
+// Cache files live under the data directory, keyed by stack/resource/region
+func cachePath(conf config.Config) string {
+	key := sha256.Sum256([]byte(conf.Stack + "/" + conf.Resource + "/" + conf.Region))
+	return filepath.Join(conf.DataDir, "cache", hex.EncodeToString(key[:])+".json")
+}
+
+func hash(metadata string) string {
+	sum := sha256.Sum256([]byte(metadata))
+	return hex.EncodeToString(sum[:])
+}
+
+// ReadCache returns the last metadata fetched for the configured resource
+func ReadCache(conf config.Config) (*CacheEntry, error) {
+	b, err := ioutil.ReadFile(cachePath(conf))
+	if err != nil {
+		return nil, err
+	}
+
+	var c CacheEntry
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// WriteCache records freshly fetched metadata, reporting whether it differs
+// from what was cached before
+func WriteCache(conf config.Config, metadata string) (changed bool, err error) {
+	now := time.Now().UTC()
+
+	c, err := ReadCache(conf)
+	if err != nil {
+		c = &CacheEntry{Stack: conf.Stack, Resource: conf.Resource, Region: conf.Region}
+	}
+
+	if h := hash(metadata); h != c.Hash {
+		c.Hash, c.Modified, c.Metadata = h, now, metadata
+		changed = true
+	}
+	c.Fetched = now
+
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return
+	}
+
+	name := cachePath(conf)
+	if err = os.MkdirAll(filepath.Dir(name), config.DataDirMode); err != nil {
+		return
+	}
+
+	// Write atomically so an interrupted run can't leave a corrupt cache
+	tmp := name + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return
+	}
+	err = os.Rename(tmp, name)
+	return
+}
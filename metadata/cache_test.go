@@ -0,0 +1,50 @@
+package metadata
+
+import (
+	"github.com/jdub/cfn-init-tools/config"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cfn-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := config.Config{Stack: "tango", Resource: "Web", Region: "us-east-1", DataDir: dir}
+	json := `{"AWS::CloudFormation::Init": {}}`
+
+	if _, err := ReadCache(conf); err == nil {
+		t.Errorf("an empty cache should be an error")
+	}
+
+	if changed, err := WriteCache(conf, json); err != nil {
+		t.Fatal(err)
+	} else if !changed {
+		t.Errorf("first write should be a change")
+	}
+
+	if changed, err := WriteCache(conf, json); err != nil {
+		t.Fatal(err)
+	} else if changed {
+		t.Errorf("identical metadata should not be a change")
+	}
+
+	if c, err := ReadCache(conf); err != nil {
+		t.Error(err)
+	} else if c.Metadata != json || !c.Fetched.After(c.Modified) {
+		t.Errorf("unexpected cache entry: %+v", c)
+	}
+
+	// A fresh cache entry is used without contacting CloudFormation
+	conf.MaxAge = time.Hour
+	if m, err := Fetch(conf); err != nil {
+		t.Error(err)
+	} else if m != json {
+		t.Errorf("%v != %v", m, json)
+	}
+}
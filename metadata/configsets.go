@@ -0,0 +1,93 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metadata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Resolve expands configSets into the ordered list of configs to run.
+// Without any configSets in the metadata, the default configSet is the
+// single config named "config", just like AWS cfn-init.
+func (init *Init) Resolve(sets []string) ([]string, error) {
+	var configs []string
+
+	for _, set := range sets {
+		if len(init.ConfigSets) == 0 && set == "default" {
+			if init.Configs["config"] == nil {
+				return nil, fmt.Errorf("No configSets, and no config named 'config' in metadata")
+			}
+			configs = append(configs, "config")
+			continue
+		}
+
+		expanded, err := init.expand(set, nil)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, expanded...)
+	}
+
+	return configs, nil
+}
+
+func (init *Init) expand(set string, seen []string) ([]string, error) {
+	for _, s := range seen {
+		if s == set {
+			return nil, fmt.Errorf("configSet %v includes itself: %v", set, strings.Join(append(seen, set), " -> "))
+		}
+	}
+	seen = append(seen, set)
+
+	items, ok := init.ConfigSets[set]
+	if !ok {
+		return nil, fmt.Errorf("configSet %v not found in metadata", set)
+	}
+
+	var configs []string
+	for _, item := range items {
+		switch i := item.(type) {
+		case string:
+			if init.Configs[i] == nil {
+				return nil, fmt.Errorf("configSet %v: config %v not found in metadata", set, i)
+			}
+			configs = append(configs, i)
+
+		case map[string]interface{}:
+			// {"ConfigSet": "name"} includes another configSet
+			name, ok := i["ConfigSet"].(string)
+			if !ok || len(i) != 1 {
+				return nil, fmt.Errorf("configSet %v: invalid item %v", set, item)
+			}
+			expanded, err := init.expand(name, seen)
+			if err != nil {
+				return nil, err
+			}
+			configs = append(configs, expanded...)
+
+		default:
+			return nil, fmt.Errorf("configSet %v: invalid item %v", set, item)
+		}
+	}
+
+	return configs, nil
+}
@@ -0,0 +1,63 @@
+package metadata
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveConfigSets(t *testing.T) {
+	json := `
+{
+    "AWS::CloudFormation::Init": {
+        "configSets": {
+            "ascending": [ "1", "2" ],
+            "descending": [ "2", "1" ],
+            "default": [ { "ConfigSet": "ascending" }, "test" ],
+            "loop": [ { "ConfigSet": "loopier" } ],
+            "loopier": [ { "ConfigSet": "loop" } ],
+            "missing": [ "pants" ]
+        },
+        "1": {},
+        "2": {},
+        "test": {}
+    }
+}
+`
+	m, err := Parse(json)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]string{
+		"default":              {"1", "2", "test"},
+		"descending,ascending": {"2", "1", "1", "2"},
+		"ascending,default":    {"1", "2", "1", "2", "test"},
+	}
+	for sets, want := range tests {
+		if configs, err := m.Init.Resolve(strings.Split(sets, ",")); err != nil {
+			t.Errorf("%v: %v", sets, err)
+		} else if !reflect.DeepEqual(configs, want) {
+			t.Errorf("%v: %v != %v", sets, configs, want)
+		}
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	for _, sets := range []string{"loop", "missing", "pants"} {
+		if _, err := m.Init.Resolve([]string{sets}); err == nil {
+			t.Errorf("%v should be an error", sets)
+		}
+	}
+}
+
+func TestResolveWithoutConfigSets(t *testing.T) {
+	m, err := Parse(`{"AWS::CloudFormation::Init": {"config": {}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configs, err := m.Init.Resolve([]string{"default"}); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(configs, []string{"config"}) {
+		t.Errorf("%v != [config]", configs)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metadata
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Kinds of change between two metadata documents
+const (
+	Added   = "+"
+	Removed = "-"
+	Changed = "~"
+)
+
+// A Change to a configSet, config, or item in a config, identified by its
+// dotted path, e.g. config.files./etc/motd
+type Change struct {
+	Kind string
+	Path string
+}
+
+func (c Change) String() string {
+	return c.Kind + " " + c.Path
+}
+
+// Diff compares two parsed metadata documents, reporting what would change on
+// the host: added, removed and changed configSets, configs, and their
+// packages, groups, users, sources, files, commands and services
+func Diff(from Metadata, to Metadata) []Change {
+	var changes []Change
+
+	oldInit, newInit := from.Init, to.Init
+	if oldInit == nil {
+		oldInit = &Init{}
+	}
+	if newInit == nil {
+		newInit = &Init{}
+	}
+
+	diffMaps(&changes, "configSets", oldInit.ConfigSets, newInit.ConfigSets)
+
+	for _, name := range keys(oldInit.Configs, newInit.Configs) {
+		a, b := oldInit.Configs[name], newInit.Configs[name]
+		switch {
+		case a == nil && b == nil:
+			continue
+		case a == nil:
+			changes = append(changes, Change{Added, name})
+		case b == nil:
+			changes = append(changes, Change{Removed, name})
+		default:
+			diffConfig(&changes, name, a, b)
+		}
+	}
+
+	return changes
+}
+
+// Sections are compared in order of execution
+func diffConfig(changes *[]Change, name string, a *Config, b *Config) {
+	pa, pb := a.Packages, b.Packages
+	if pa == nil {
+		pa = &Package{}
+	}
+	if pb == nil {
+		pb = &Package{}
+	}
+	diffMaps(changes, name+".packages.msi", pa.Msi, pb.Msi)
+	diffMaps(changes, name+".packages.rpm", pa.Rpm, pb.Rpm)
+	diffMaps(changes, name+".packages.yum", pa.Yum, pb.Yum)
+	diffMaps(changes, name+".packages.apt", pa.Apt, pb.Apt)
+	diffMaps(changes, name+".packages.python", pa.Python, pb.Python)
+	diffMaps(changes, name+".packages.rubygems", pa.RubyGems, pb.RubyGems)
+
+	diffMaps(changes, name+".groups", a.Groups, b.Groups)
+	diffMaps(changes, name+".users", a.Users, b.Users)
+	diffMaps(changes, name+".sources", a.Sources, b.Sources)
+	diffMaps(changes, name+".files", a.Files, b.Files)
+	diffMaps(changes, name+".commands", a.Commands, b.Commands)
+
+	sa, sb := a.Services, b.Services
+	if sa == nil {
+		sa = &ServiceManager{}
+	}
+	if sb == nil {
+		sb = &ServiceManager{}
+	}
+	diffMaps(changes, name+".services.sysvinit", sa.SysVInit, sb.SysVInit)
+	diffMaps(changes, name+".services.windows", sa.Windows, sb.Windows)
+}
+
+// Compares two maps of the same type, keyed by string
+func diffMaps(changes *[]Change, path string, a interface{}, b interface{}) {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+
+	for _, k := range keys(a, b) {
+		key := reflect.ValueOf(k)
+		ea, eb := va.MapIndex(key), vb.MapIndex(key)
+		p := fmt.Sprintf("%v.%v", path, k)
+
+		switch {
+		case !ea.IsValid():
+			*changes = append(*changes, Change{Added, p})
+		case !eb.IsValid():
+			*changes = append(*changes, Change{Removed, p})
+		case !reflect.DeepEqual(ea.Interface(), eb.Interface()):
+			*changes = append(*changes, Change{Changed, p})
+		}
+	}
+}
+
+// The sorted union of the keys of two maps keyed by string
+func keys(a interface{}, b interface{}) []string {
+	seen := make(map[string]bool)
+	for _, m := range []interface{}{a, b} {
+		for _, k := range reflect.ValueOf(m).MapKeys() {
+			seen[k.String()] = true
+		}
+	}
+
+	list := make([]string, 0, len(seen))
+	for k := range seen {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
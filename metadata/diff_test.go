@@ -0,0 +1,81 @@
+package metadata
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := `
+{
+    "AWS::CloudFormation::Init": {
+        "configSets": { "default": [ "config" ], "gone": [ "config" ] },
+        "config": {
+            "packages": { "yum": { "nginx": [], "httpd": [] } },
+            "files": {
+                "/etc/motd": { "content": "hello" },
+                "/etc/issue": { "content": "hello" }
+            },
+            "commands": { "restart": { "command": "service nginx restart" } },
+            "services": { "sysvinit": { "nginx": { "enabled": true } } }
+        },
+        "legacy": {}
+    }
+}
+`
+	updated := `
+{
+    "AWS::CloudFormation::Init": {
+        "configSets": { "default": [ "config", "extra" ] },
+        "config": {
+            "packages": { "yum": { "nginx": [ "1.10.1" ] } },
+            "users": { "deploy": { "homeDir": "/srv" } },
+            "files": {
+                "/etc/motd": { "content": "hello" },
+                "/etc/issue": { "content": "goodbye" }
+            },
+            "commands": { "restart": { "command": "service nginx restart" } },
+            "services": { "sysvinit": { "nginx": { "enabled": true, "ensureRunning": true } } }
+        },
+        "extra": {}
+    }
+}
+`
+	a, err := Parse(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Parse(updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Change{
+		{Changed, "configSets.default"},
+		{Removed, "configSets.gone"},
+		{Changed, "config.packages.yum.nginx"},
+		{Removed, "config.packages.yum.httpd"},
+		{Added, "config.users.deploy"},
+		{Changed, "config.files./etc/issue"},
+		{Changed, "config.services.sysvinit.nginx"},
+		{Added, "extra"},
+		{Removed, "legacy"},
+	}
+	changes := Diff(a, b)
+
+	// Compare as sets; ordering is by section, then key
+	set := func(c []Change) map[Change]bool {
+		m := make(map[Change]bool)
+		for _, change := range c {
+			m[change] = true
+		}
+		return m
+	}
+	if !reflect.DeepEqual(set(changes), set(want)) {
+		t.Errorf("%v != %v", changes, want)
+	}
+
+	if changes := Diff(a, a); len(changes) != 0 {
+		t.Errorf("identical metadata should have no changes: %v", changes)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metadata
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/jdub/cfn-init-tools/config"
+	"golang.org/x/net/http/httpproxy"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// HTTPClient returns the client used for every request the tool makes, with
+// the configured proxies, timeout and TLS options: a private CA bundle (in
+// addition to the system roots), a client certificate, and for local testing
+// only, skipping verification altogether
+func HTTPClient(conf config.Config) (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+
+	if conf.CaBundle != "" {
+		pem, err := ioutil.ReadFile(conf.CaBundle)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in CA bundle %v", conf.CaBundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if conf.ClientCert != "" || conf.ClientKey != "" {
+		if conf.ClientCert == "" || conf.ClientKey == "" {
+			return nil, fmt.Errorf("You must pass both --client-cert and --client-key")
+		}
+		cert, err := tls.LoadX509KeyPair(conf.ClientCert, conf.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if conf.InsecureSkipVerify {
+		fmt.Fprintln(os.Stderr, "Warning: TLS certificate verification is disabled")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	proxy, err := proxyFunc(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the default dial, TLS handshake and idle timeouts
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.TLSClientConfig = tlsConfig
+	transport.ResponseHeaderTimeout = ResponseHeaderTimeout
+
+	if conf.HttpTimeout <= 0 {
+		return &http.Client{Transport: transport}, nil
+	}
+	return &http.Client{Transport: &idleTimeout{transport, conf.HttpTimeout}}, nil
+}
+
+// Gives up on a request when no data arrives for a while, rather than after a
+// fixed time, so a large download that keeps making progress is never cut off
+type idleTimeout struct {
+	transport http.RoundTripper
+	timeout   time.Duration
+}
+
+func (t *idleTimeout) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	b := &idleBody{timeout: t.timeout, cancel: cancel}
+	b.timer = time.AfterFunc(t.timeout, b.expire)
+
+	res, err := t.transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		b.timer.Stop()
+		cancel()
+		return nil, b.err(err)
+	}
+	b.body = res.Body
+	res.Body = b
+	return res, nil
+}
+
+type idleBody struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	cancel  context.CancelFunc
+	expired int32
+}
+
+func (b *idleBody) expire() {
+	atomic.StoreInt32(&b.expired, 1)
+	b.cancel()
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 && atomic.LoadInt32(&b.expired) == 0 {
+		b.timer.Reset(b.timeout)
+	}
+	return n, b.err(err)
+}
+
+func (b *idleBody) Close() error {
+	b.timer.Stop()
+	b.cancel()
+	return b.body.Close()
+}
+
+func (b *idleBody) err(err error) error {
+	if err != nil && err != io.EOF && atomic.LoadInt32(&b.expired) == 1 {
+		return fmt.Errorf("No data received for %v: %v", b.timeout, err)
+	}
+	return err
+}
+
+// ResponseHeaderTimeout bounds how long a server may take to start responding
+const ResponseHeaderTimeout = 30 * time.Second
+
+// Uses --http-proxy and --https-proxy, falling back to the environment,
+// including NO_PROXY. The instance metadata service is never proxied.
+func proxyFunc(conf config.Config) (func(*http.Request) (*url.URL, error), error) {
+	proxies := httpproxy.FromEnvironment()
+	for scheme, proxy := range map[string]string{"http": conf.HttpProxy, "https": conf.HttpsProxy} {
+		if proxy == "" {
+			continue
+		}
+		u, err := url.Parse(proxy)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid %v proxy url: %v", scheme, proxy)
+		}
+		if scheme == "http" {
+			proxies.HTTPProxy = proxy
+		} else {
+			proxies.HTTPSProxy = proxy
+		}
+	}
+
+	proxy := proxies.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		if isLinkLocal(req.URL.Hostname()) {
+			return nil, nil
+		}
+		return proxy(req.URL)
+	}, nil
+}
+
+// Link-local addresses, including the instance metadata service at
+// 169.254.169.254 and fd00:ec2::254, can't be reached through a proxy
+func isLinkLocal(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLinkLocalUnicast() || ip.Equal(net.ParseIP("fd00:ec2::254")))
+}
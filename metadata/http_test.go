@@ -0,0 +1,141 @@
+package metadata
+
+import (
+	"encoding/pem"
+	"github.com/jdub/cfn-init-tools/config"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHTTPClientTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pants"))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "cfn-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bundle := filepath.Join(dir, "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(bundle, cert, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(conf config.Config) error {
+		client, err := HTTPClient(conf)
+		if err != nil {
+			return err
+		}
+		res, err := client.Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		return res.Body.Close()
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	if err := get(config.Config{}); err == nil {
+		t.Errorf("an unknown CA should not be trusted")
+	}
+	if err := get(config.Config{CaBundle: bundle}); err != nil {
+		t.Errorf("the CA bundle should be trusted: %v", err)
+	}
+	if err := get(config.Config{InsecureSkipVerify: true}); err != nil {
+		t.Errorf("verification should be skipped: %v", err)
+	}
+
+	if _, err := HTTPClient(config.Config{ClientCert: bundle}); err == nil {
+		t.Errorf("--client-cert without --client-key should be an error")
+	}
+	if _, err := HTTPClient(config.Config{CaBundle: filepath.Join(dir, "missing.pem")}); err == nil {
+		t.Errorf("a missing CA bundle should be an error")
+	}
+}
+
+func TestProxy(t *testing.T) {
+	t.Setenv("NO_PROXY", "internal.example.com")
+	t.Setenv("HTTPS_PROXY", "http://env-proxy:3128")
+
+	proxy, err := proxyFunc(config.Config{HttpProxy: "http://proxy:3128"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"http://example.com/":                       "http://proxy:3128",
+		"https://example.com/":                      "http://env-proxy:3128",
+		"http://internal.example.com/":              "",
+		"http://169.254.169.254/latest/meta-data/":  "",
+		"http://[fd00:ec2::254]/latest/meta-data/":  "",
+		"http://169.254.170.2/v2/credentials/pants": "",
+	}
+	for target, want := range tests {
+		req, _ := http.NewRequest("GET", target, nil)
+		u, err := proxy(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if u != nil {
+			got = u.String()
+		}
+		if got != want {
+			t.Errorf("%v: %q != %q", target, got, want)
+		}
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	if _, err := proxyFunc(config.Config{HttpsProxy: "pants"}); err == nil {
+		t.Error("invalid proxy should be an error")
+	}
+}
+
+func TestHTTPClientIdleTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Trickle the response out, taking longer than the timeout overall
+		for i := 0; i < 10; i++ {
+			w.Write([]byte("pants"))
+			w.(http.Flusher).Flush()
+			if r.URL.Path == "/stalled" && i == 1 {
+				<-r.Context().Done()
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := HTTPClient(config.Config{HttpTimeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(path string) (string, error) {
+		res, err := client.Get(srv.URL + path)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		b, err := ioutil.ReadAll(res.Body)
+		return string(b), err
+	}
+
+	if b, err := get("/slow"); err != nil {
+		t.Errorf("a slow response making progress should not time out: %v", err)
+	} else if len(b) != 50 {
+		t.Errorf("%v bytes != 50", len(b))
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	if _, err := get("/stalled"); err == nil {
+		t.Error("a stalled response should time out")
+	}
+}
@@ -0,0 +1,373 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metadata
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Parameters are the values substituted for Ref when resolving intrinsic
+// functions locally
+type Parameters map[string]string
+
+// ReadParameters reads a parameters file, either in the `aws cloudformation`
+// CLI format ([{"ParameterKey": "K", "ParameterValue": "V"}]) or as a plain
+// object ({"K": "V"})
+func ReadParameters(name string) (Parameters, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []struct {
+		ParameterKey   string
+		ParameterValue string
+	}
+	if err := json.Unmarshal(b, &list); err == nil {
+		p := make(Parameters, len(list))
+		for _, kv := range list {
+			p[kv.ParameterKey] = kv.ParameterValue
+		}
+		return p, nil
+	}
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil, fmt.Errorf("Could not read parameters from %v: %v", name, err)
+	}
+
+	p := make(Parameters, len(obj))
+	for k, v := range obj {
+		if s, ok := v.(string); ok {
+			p[k] = s
+		} else {
+			p[k] = fmt.Sprint(v)
+		}
+	}
+	return p, nil
+}
+
+// Resolve evaluates the intrinsic functions in metadata that can be handled
+// locally: Ref, Fn::Join, Fn::Sub, Fn::Base64 and Fn::Select. See
+// ResolveTemplate for the additional functions available with a template.
+func Resolve(metadata string, params Parameters) (string, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(metadata), &v); err != nil {
+		return "", err
+	}
+
+	e := &evaluator{params: params}
+	v, err := e.eval(v)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// Unresolved returns the dotted key and function name of every intrinsic
+// function found in v
+func Unresolved(v interface{}) []string {
+	var found []string
+	unresolved(v, "", &found)
+	sort.Strings(found)
+	return found
+}
+
+func unresolved(v interface{}, path string, found *[]string) {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		if fn, _, ok := intrinsic(node); ok {
+			*found = append(*found, path+": "+fn)
+			return
+		}
+		for k, child := range node {
+			unresolved(child, joinKey(path, k), found)
+		}
+	case []interface{}:
+		for i, child := range node {
+			unresolved(child, joinKey(path, strconv.Itoa(i)), found)
+		}
+	}
+}
+
+func joinKey(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// An intrinsic function is an object with a single Ref, Condition or Fn:: key
+func intrinsic(m map[string]interface{}) (fn string, arg interface{}, ok bool) {
+	if len(m) != 1 {
+		return
+	}
+	for fn, arg = range m {
+		break
+	}
+	ok = fn == "Ref" || fn == "Condition" || strings.HasPrefix(fn, "Fn::")
+	return
+}
+
+type evaluator struct {
+	params Parameters
+	// Only available when resolving a whole template
+	template   *Template
+	conditions map[string]bool
+	evaluating map[string]bool
+}
+
+// Ref to AWS::NoValue removes the property it's assigned to
+type noValue struct{}
+
+func (e *evaluator) eval(v interface{}) (interface{}, error) {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		if fn, arg, ok := intrinsic(node); ok {
+			return e.call(fn, arg)
+		}
+		for k, child := range node {
+			r, err := e.eval(child)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := r.(noValue); ok {
+				delete(node, k)
+			} else {
+				node[k] = r
+			}
+		}
+	case []interface{}:
+		list := node[:0]
+		for _, child := range node {
+			r, err := e.eval(child)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := r.(noValue); !ok {
+				list = append(list, r)
+			}
+		}
+		return list, nil
+	}
+	return v, nil
+}
+
+func (e *evaluator) call(fn string, arg interface{}) (interface{}, error) {
+	// Only the chosen branch of Fn::If is evaluated
+	if fn == "Fn::If" && e.template != nil {
+		args, ok := arg.([]interface{})
+		if !ok || len(args) != 3 {
+			return nil, fmt.Errorf("Fn::If: expected [condition, true value, false value]")
+		}
+		name, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("Fn::If: expected a condition name, got %v", args[0])
+		}
+		cond, err := e.condition(name)
+		if err != nil {
+			return nil, err
+		}
+		if cond {
+			return e.eval(args[1])
+		}
+		return e.eval(args[2])
+	}
+
+	// Arguments may themselves contain intrinsic functions
+	arg, err := e.eval(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.template != nil {
+		if v, ok, err := e.callTemplate(fn, arg); ok {
+			return v, err
+		}
+	}
+
+	switch fn {
+	case "Ref":
+		name, ok := arg.(string)
+		if !ok {
+			return nil, fmt.Errorf("Ref: expected a name, got %v", arg)
+		}
+		return e.ref(name)
+
+	case "Fn::Join":
+		args, ok := arg.([]interface{})
+		if !ok || len(args) != 2 {
+			return nil, fmt.Errorf("Fn::Join: expected [delimiter, [values]]")
+		}
+		delim, ok := args[0].(string)
+		list, ok2 := args[1].([]interface{})
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("Fn::Join: expected [delimiter, [values]]")
+		}
+		parts := make([]string, len(list))
+		for i, item := range list {
+			if parts[i], err = scalar(item); err != nil {
+				return nil, fmt.Errorf("Fn::Join: %v", err)
+			}
+		}
+		return strings.Join(parts, delim), nil
+
+	case "Fn::Sub":
+		var vars map[string]interface{}
+		s, ok := arg.(string)
+		if args, isList := arg.([]interface{}); isList && len(args) == 2 {
+			s, ok = args[0].(string)
+			vars, _ = args[1].(map[string]interface{})
+		}
+		if !ok {
+			return nil, fmt.Errorf("Fn::Sub: expected a string or [string, {variables}]")
+		}
+		return e.sub(s, vars)
+
+	case "Fn::Base64":
+		s, err := scalar(arg)
+		if err != nil {
+			return nil, fmt.Errorf("Fn::Base64: %v", err)
+		}
+		return base64.StdEncoding.EncodeToString([]byte(s)), nil
+
+	case "Fn::Select":
+		args, ok := arg.([]interface{})
+		if !ok || len(args) != 2 {
+			return nil, fmt.Errorf("Fn::Select: expected [index, [values]]")
+		}
+		index, err := scalar(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("Fn::Select: %v", err)
+		}
+		n, err := strconv.Atoi(index)
+		if err != nil {
+			return nil, fmt.Errorf("Fn::Select: invalid index %v", index)
+		}
+		list, ok := args[1].([]interface{})
+		if s, isString := args[1].(string); isString {
+			// A comma-delimited list parameter
+			list, ok = listOf(s), true
+		}
+		if !ok || n < 0 || n >= len(list) {
+			return nil, fmt.Errorf("Fn::Select: index %v out of range", n)
+		}
+		return list[n], nil
+	}
+
+	return nil, fmt.Errorf("%v cannot be resolved locally", fn)
+}
+
+func (e *evaluator) ref(name string) (interface{}, error) {
+	if name == "AWS::NoValue" {
+		return noValue{}, nil
+	}
+	if v, ok := e.params[name]; ok {
+		if e.template != nil && e.template.isList(name) {
+			return listOf(v), nil
+		}
+		return v, nil
+	}
+	if e.template != nil {
+		if _, ok := e.template.Resources[name]; ok {
+			// The physical ID can't be known locally, so stub it
+			return name, nil
+		}
+	}
+	if name == "AWS::AccountId" || name == "AWS::StackId" {
+		return nil, fmt.Errorf("Ref: no value for %v, pass --account-id or set it in --parameters", name)
+	}
+	return nil, fmt.Errorf("Ref: no value for parameter %v", name)
+}
+
+var subVariable = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+func (e *evaluator) sub(s string, vars map[string]interface{}) (string, error) {
+	var failed error
+	out := subVariable.ReplaceAllStringFunc(s, func(match string) string {
+		name := strings.TrimSpace(match[2 : len(match)-1])
+
+		// ${!Literal} is written out as ${Literal}
+		if strings.HasPrefix(name, "!") {
+			return "${" + name[1:] + "}"
+		}
+
+		v, ok := vars[name]
+		if !ok && e.template != nil && strings.Contains(name, ".") {
+			// ${Resource.Attribute} is shorthand for Fn::GetAtt
+			v, ok = e.getAtt(strings.SplitN(name, ".", 2)), true
+		}
+		if !ok {
+			var err error
+			if v, err = e.ref(name); err != nil {
+				failed = err
+				return match
+			}
+		}
+
+		r, err := scalar(v)
+		if err != nil {
+			failed = err
+			return match
+		}
+		return r
+	})
+	if failed != nil {
+		return "", fmt.Errorf("Fn::Sub: %v", failed)
+	}
+	return out, nil
+}
+
+func listOf(s string) []interface{} {
+	var list []interface{}
+	for _, item := range strings.Split(s, ",") {
+		list = append(list, strings.TrimSpace(item))
+	}
+	return list
+}
+
+// Formats a resolved value as a string
+func scalar(v interface{}) (string, error) {
+	switch s := v.(type) {
+	case string:
+		return s, nil
+	case []interface{}:
+		return "", fmt.Errorf("expected a string, got a list")
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(s), nil
+	}
+	return "", fmt.Errorf("expected a string, got %v", v)
+}
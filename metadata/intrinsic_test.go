@@ -0,0 +1,91 @@
+package metadata
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const intrinsicJson = `
+{
+    "AWS::CloudFormation::Init": {
+        "config": {
+            "files": {
+                "/etc/app.conf": {
+                    "content": { "Fn::Join": [ "", [ "bucket=", { "Ref": "Bucket" }, "\n" ] ] }
+                },
+                "/etc/motd": {
+                    "content": { "Fn::Sub": [ "Welcome to ${Name} in ${Env} ${!Literal}", { "Env": "prod" } ] }
+                },
+                "/etc/secret": {
+                    "content": { "Fn::Base64": { "Fn::Select": [ "1", { "Ref": "Zones" } ] } }
+                }
+            }
+        }
+    }
+}
+`
+
+func TestUnresolved(t *testing.T) {
+	// No, Mr. Bond, I expect you to die!
+	if _, err := Parse(intrinsicJson); err == nil {
+		t.Errorf("intrinsic functions should be detected")
+	} else if !strings.Contains(err.Error(), "AWS::CloudFormation::Init.config.files./etc/app.conf.content: Fn::Join") {
+		t.Errorf("error should name the unresolved key: %v", err)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	params := Parameters{"Bucket": "pants", "Name": "tango", "Zones": "a,b,c"}
+
+	j, err := Resolve(intrinsicJson, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := Parse(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := m.Init.Configs["config"].Files
+	if c := files["/etc/app.conf"].Content; c != "bucket=pants\n" {
+		t.Errorf("Fn::Join resolved to %q", c)
+	}
+	if c := files["/etc/motd"].Content; c != "Welcome to tango in prod ${Literal}" {
+		t.Errorf("Fn::Sub resolved to %q", c)
+	}
+	if c := files["/etc/secret"].Content; c != "Yg==" {
+		t.Errorf("Fn::Base64 of Fn::Select resolved to %q", c)
+	}
+
+	if _, err := Resolve(intrinsicJson, Parameters{}); err == nil {
+		t.Errorf("missing parameters should be an error")
+	}
+}
+
+func TestReadParameters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cfn-params")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"cli.json":   `[{"ParameterKey": "Bucket", "ParameterValue": "pants"}]`,
+		"plain.json": `{"Bucket": "pants"}`,
+	}
+	for name, content := range files {
+		name = filepath.Join(dir, name)
+		if err := ioutil.WriteFile(name, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if p, err := ReadParameters(name); err != nil {
+			t.Error(err)
+		} else if p["Bucket"] != "pants" {
+			t.Errorf("%v: Bucket = %q", name, p["Bucket"])
+		}
+	}
+}
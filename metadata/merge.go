@@ -0,0 +1,120 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// How configSets with the same name are merged
+const (
+	MergeReplace = "replace"
+	MergeConcat  = "concat"
+)
+
+// Merge deep-merges metadata documents into one, later documents taking
+// precedence over earlier ones:
+//
+//   - objects are merged key by key
+//   - a command or file defined in both is replaced whole by the later one,
+//     with a warning
+//   - a configSet defined in both is replaced by the later one, or with
+//     configSets set to MergeConcat, the later one is appended to it
+//   - anything else (strings, lists, ...) is replaced by the later value
+func Merge(docs []string, configSets string) (merged string, warnings []string, err error) {
+	if configSets == "" {
+		configSets = MergeReplace
+	} else if configSets != MergeReplace && configSets != MergeConcat {
+		return "", nil, fmt.Errorf("Unknown configSets merge mode %q, must be %v or %v", configSets, MergeReplace, MergeConcat)
+	}
+
+	result := make(map[string]interface{})
+	for i, doc := range docs {
+		var v map[string]interface{}
+		if err := json.Unmarshal([]byte(doc), &v); err != nil {
+			return "", nil, fmt.Errorf("metadata document %d: %v", i+1, err)
+		}
+
+		m := &merger{configSets: configSets, doc: i + 1}
+		m.object(result, v, nil)
+		warnings = append(warnings, m.warnings...)
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return string(b), warnings, nil
+}
+
+type merger struct {
+	configSets string
+	doc        int
+	warnings   []string
+}
+
+func (m *merger) object(dst map[string]interface{}, src map[string]interface{}, path []string) {
+	// Sorted for predictable warnings
+	keys := make([]string, 0, len(src))
+	for k := range src {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := src[k]
+		at := append(path[:len(path):len(path)], k)
+
+		old, exists := dst[k]
+		if !exists {
+			dst[k] = v
+			continue
+		}
+
+		// AWS::CloudFormation::Init.<config>.{commands,files}.<name>
+		if len(at) == 4 && at[0] == "AWS::CloudFormation::Init" && (at[2] == "commands" || at[2] == "files") {
+			m.warnings = append(m.warnings, fmt.Sprintf("metadata document %d overrides %v %q in config %q", m.doc, at[2], k, at[1]))
+			dst[k] = v
+			continue
+		}
+
+		// AWS::CloudFormation::Init.configSets.<name>
+		if len(at) == 3 && at[0] == "AWS::CloudFormation::Init" && at[1] == "configSets" && m.configSets == MergeConcat {
+			oldList, ok1 := old.([]interface{})
+			newList, ok2 := v.([]interface{})
+			if ok1 && ok2 {
+				dst[k] = append(oldList, newList...)
+				continue
+			}
+		}
+
+		oldObj, ok1 := old.(map[string]interface{})
+		newObj, ok2 := v.(map[string]interface{})
+		if ok1 && ok2 {
+			m.object(oldObj, newObj, at)
+		} else {
+			dst[k] = v
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package metadata
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const mergeBase = `
+{
+    "AWS::CloudFormation::Init": {
+        "configSets": { "default": [ "base" ] },
+        "base": {
+            "packages": { "yum": { "nginx": [] } },
+            "files": {
+                "/etc/motd": { "content": "base", "mode": "000644" }
+            },
+            "commands": {
+                "restart": { "command": "service nginx restart", "ignoreErrors": true }
+            }
+        }
+    }
+}
+`
+
+const mergeOverlay = `
+{
+    "AWS::CloudFormation::Init": {
+        "configSets": { "default": [ "web" ] },
+        "base": {
+            "packages": { "yum": { "git": [] } },
+            "files": {
+                "/etc/motd": { "content": "web" }
+            }
+        },
+        "web": {}
+    }
+}
+`
+
+func TestMerge(t *testing.T) {
+	j, warnings, err := Merge([]string{mergeBase, mergeOverlay}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(warnings) != 1 || !strings.Contains(warnings[0], `files "/etc/motd" in config "base"`) {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+
+	for _, pkg := range []string{"nginx", "git"} {
+		if _, err := Value(j, "AWS::CloudFormation::Init.base.packages.yum."+pkg); err != nil {
+			t.Errorf("packages should be merged: %v", err)
+		}
+	}
+
+	m, err := Parse(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := m.Init.Configs["base"]
+	if f := base.Files["/etc/motd"]; f.Content != "web" || f.Mode != "" {
+		t.Errorf("files should be replaced whole by the later one: %+v", f)
+	}
+	if c := base.Commands["restart"]; c == nil || c.IgnoreErrors != true {
+		t.Errorf("commands only in the base should be kept: %+v", c)
+	}
+	if m.Init.Configs["web"] == nil {
+		t.Errorf("configs only in the overlay should be added")
+	}
+	if sets := m.Init.ConfigSets["default"]; !reflect.DeepEqual(sets, []interface{}{"web"}) {
+		t.Errorf("configSets should be replaced: %v", sets)
+	}
+
+	j, _, err = Merge([]string{mergeBase, mergeOverlay}, MergeConcat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, err := Parse(j); err != nil {
+		t.Error(err)
+	} else if sets := m.Init.ConfigSets["default"]; !reflect.DeepEqual(sets, []interface{}{"base", "web"}) {
+		t.Errorf("configSets should be concatenated: %v", sets)
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	if _, _, err := Merge([]string{mergeBase}, "pants"); err == nil {
+		t.Errorf("unknown configSets merge modes should be an error")
+	}
+}
@@ -23,55 +23,39 @@ package metadata
 import (
 	"encoding/json"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/jdub/cfn-init-tools/config"
-	"io/ioutil"
-	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// Fetch returns the raw metadata from the configured source
 func Fetch(conf config.Config) (metadata string, err error) {
-	if conf.Local != "" {
-		if b, err := ioutil.ReadFile(conf.Local); err != nil {
-			return "", err
-		} else {
-			return string(b), nil
-		}
-	}
-
-	endpoint := ""
-	if conf.Url != "" {
-		if u, err := url.Parse(conf.Url); err != nil {
-			return "", err
-		} else if u.Scheme == "" {
-			return "", fmt.Errorf("invalid endpoint url: %v", conf.Url)
-		} else {
-			endpoint = u.String()
-		}
-	}
-
-	svc := cloudformation.New(session.New(), &aws.Config{
-		Region:   aws.String(conf.Region),
-		Endpoint: aws.String(endpoint),
-	})
-
-	params := &cloudformation.DescribeStackResourceInput{
-		LogicalResourceId: aws.String(conf.Resource),
-		StackName:         aws.String(conf.Stack),
-	}
-
-	res, err := svc.DescribeStackResource(params)
+	src, err := NewSource(conf)
 	if err != nil {
 		return "", err
 	}
-
-	return *res.StackResourceDetail.Metadata, nil
+	return src.Fetch()
 }
 
 func Parse(metadata string) (m Metadata, err error) {
 	bytes := []byte(metadata)
+
+	// Catch intrinsic functions before they fail to unmarshal, or worse, are
+	// written verbatim into config files
+	var raw map[string]interface{}
+	if err = json.Unmarshal(bytes, &raw); err != nil {
+		return
+	}
+	tree := map[string]interface{}{
+		"AWS::CloudFormation::Authentication": raw["AWS::CloudFormation::Authentication"],
+		"AWS::CloudFormation::Init":           raw["AWS::CloudFormation::Init"],
+	}
+	if fns := Unresolved(tree); len(fns) > 0 {
+		err = fmt.Errorf("Unresolved intrinsic functions in metadata (pass --parameters to resolve them locally):\n  %v", strings.Join(fns, "\n  "))
+		return
+	}
+
 	if err = json.Unmarshal(bytes, &m); err != nil {
 		return
 	}
@@ -95,21 +79,7 @@ func Parse(metadata string) (m Metadata, err error) {
 }
 
 func Json(metadata string, key string) (j string, err error) {
-	bytes := []byte(metadata)
-
-	var d map[string]interface{}
-	if err := json.Unmarshal(bytes, &d); err != nil {
-		return "", err
-	}
-
-	// FIXME: only return value at dotted object path, e.g. config.commands
-
-	b, err := json.MarshalIndent(d, "", "  ")
-	if err != nil {
-		return "", err
-	}
-
-	return string(b), nil
+	return Output(metadata, key, "json")
 }
 
 type Metadata struct {
@@ -141,7 +111,7 @@ type Configs struct {
 
 // Arranged in order of execution
 type Config struct {
-	Packages map[string]*Package `json:"packages"`
+	Packages *Package            `json:"packages"`
 	Groups   map[string]*Group   `json:"groups"`
 	Users    map[string]*User    `json:"users"`
 	Sources  map[string]string   `json:"sources"`
@@ -178,6 +148,8 @@ type File struct {
 	Mode           string                     `json:"mode"`
 	Authentication string                     `json:"authentication"`
 	Context        map[string]json.RawMessage `json:"context"`
+	// Not supported by AWS cfn-init, which ignores it
+	Sha256 string `json:"sha256"`
 }
 
 type Command struct {
@@ -187,6 +159,10 @@ type Command struct {
 	Test                string            `json:"test"`
 	IgnoreErrors        JavaScriptBoolean `json:"ignoreErrors"`
 	WaitAfterCompletion JavaScriptBoolean `json:"waitAfterCompletion"`
+	// Not supported by AWS cfn-init, which ignores them
+	Timeout Duration `json:"timeout"`
+	User    string   `json:"user"`
+	Group   string   `json:"group"`
 }
 
 type ServiceManager struct {
@@ -216,3 +192,22 @@ func (bit *JavaScriptBoolean) UnmarshalJSON(data []byte) error {
 	}
 	return nil
 }
+
+// A Duration is a number of seconds, like waitAfterCompletion, or a string
+// such as "90" or "5m"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		*d = Duration(seconds * float64(time.Second))
+	} else if parsed, err := time.ParseDuration(s); err == nil {
+		*d = Duration(parsed)
+	} else {
+		return fmt.Errorf("Duration unmarshal error: invalid input %s", s)
+	}
+	if *d < 0 {
+		return fmt.Errorf("Duration unmarshal error: negative duration %s", s)
+	}
+	return nil
+}
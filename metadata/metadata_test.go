@@ -1,7 +1,9 @@
 package metadata
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 )
 
 func TestJson(t *testing.T) {
@@ -103,6 +105,31 @@ func TestUnmarshalTruthyJSON(t *testing.T) {
 	}
 }
 
+func TestUnmarshalDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		`300`:   300 * time.Second,
+		`"1.5"`: 1500 * time.Millisecond,
+		`"5m"`:  5 * time.Minute,
+		`"0"`:   0,
+	}
+	for input, want := range tests {
+		var d Duration
+		if err := json.Unmarshal([]byte(input), &d); err != nil {
+			t.Errorf("%v: %v", input, err)
+		} else if time.Duration(d) != want {
+			t.Errorf("%v: %v != %v", input, time.Duration(d), want)
+		}
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	for _, input := range []string{`"pants"`, `-1`, `"-5m"`} {
+		var d Duration
+		if err := json.Unmarshal([]byte(input), &d); err == nil {
+			t.Errorf("%v should be an error", input)
+		}
+	}
+}
+
 func TestConfigSets(t *testing.T) {
 	json := `
 {
@@ -0,0 +1,194 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Output formats understood by Output
+var Formats = []string{"json", "compact", "yaml", "raw", "env"}
+
+// Value returns the value at key in the metadata, which must be in dotted
+// object notation (parent.child.leaf). An empty key returns the whole thing.
+func Value(metadata string, key string) (v interface{}, err error) {
+	if err = json.Unmarshal([]byte(metadata), &v); err != nil {
+		return
+	}
+
+	if key == "" {
+		return
+	}
+
+	parts := strings.Split(key, ".")
+	for i := 0; i < len(parts); {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			// Object keys may contain dots (e.g. file names), so prefer the
+			// longest run of parts that names an existing key
+			found := false
+			for j := len(parts); j > i; j-- {
+				if child, ok := node[strings.Join(parts[i:j], ".")]; ok {
+					v, i, found = child, j, true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("Key %q not found in metadata", strings.Join(parts[:i+1], "."))
+			}
+
+		case []interface{}:
+			n, err := strconv.Atoi(parts[i])
+			if err != nil || n < 0 || n >= len(node) {
+				return nil, fmt.Errorf("Key %q not found in metadata", strings.Join(parts[:i+1], "."))
+			}
+			v, i = node[n], i+1
+
+		default:
+			return nil, fmt.Errorf("Key %q not found in metadata", strings.Join(parts[:i+1], "."))
+		}
+	}
+
+	return
+}
+
+// Output renders the value at key in the metadata in the requested format:
+//
+//	json     indented JSON
+//	compact  JSON on a single line
+//	yaml     YAML
+//	raw      strings without quotes, anything else as compact JSON
+//	env      an object flattened into KEY=value lines
+func Output(metadata string, key string, format string) (string, error) {
+	v, err := Value(metadata, key)
+	if err != nil {
+		return "", err
+	}
+
+	switch format {
+	case "", "json":
+		b, err := json.MarshalIndent(v, "", "  ")
+		return string(b), err
+
+	case "compact":
+		b, err := json.Marshal(v)
+		return string(b), err
+
+	case "yaml":
+		b, err := yaml.Marshal(v)
+		return strings.TrimSuffix(string(b), "\n"), err
+
+	case "raw":
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		b, err := json.Marshal(v)
+		return string(b), err
+
+	case "env":
+		if _, ok := v.(map[string]interface{}); !ok {
+			return "", fmt.Errorf("Output format env requires an object, use --key to select one")
+		}
+		env := make(map[string]string)
+		if err := flatten(env, make(map[string]string), "", "", v); err != nil {
+			return "", err
+		}
+		lines := make([]string, 0, len(env))
+		for k, s := range env {
+			lines = append(lines, k+"="+shellQuote(s))
+		}
+		sort.Strings(lines)
+		return strings.Join(lines, "\n"), nil
+	}
+
+	return "", fmt.Errorf("Unknown output format %q, must be one of: %s", format, strings.Join(Formats, ", "))
+}
+
+func flatten(env map[string]string, keys map[string]string, prefix string, key string, v interface{}) error {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for k, child := range node {
+			if err := flatten(env, keys, envName(prefix, k), joinKey(key, k), child); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		for i, child := range node {
+			if err := flatten(env, keys, envName(prefix, strconv.Itoa(i)), joinKey(key, strconv.Itoa(i)), child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Different keys, e.g. a-b and a_b, or a.b and a_b, may become the same
+	// name, and which one wins would depend on map order
+	if other, ok := keys[prefix]; ok {
+		pair := []string{other, key}
+		sort.Strings(pair)
+		return fmt.Errorf("Keys %q and %q are both output as %v", pair[0], pair[1], prefix)
+	}
+	keys[prefix] = key
+
+	switch node := v.(type) {
+	case string:
+		env[prefix] = node
+	case nil:
+		env[prefix] = ""
+	default:
+		b, _ := json.Marshal(node)
+		env[prefix] = string(b)
+	}
+	return nil
+}
+
+// Upper-cases name and replaces anything that isn't valid in a shell variable
+func envName(prefix string, name string) string {
+	b := []byte(strings.ToUpper(name))
+	for i, c := range b {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	if prefix != "" {
+		return prefix + "_" + string(b)
+	}
+	if len(b) > 0 && b[0] >= '0' && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
+
+// Single-quotes s when necessary, suitable for eval and EnvironmentFile
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("@%+=:,./_-", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,76 @@
+package metadata
+
+import (
+	"testing"
+)
+
+const outputJson = `
+{
+    "AWS::CloudFormation::Init": {
+        "config": {
+            "files": {
+                "/etc/nginx/nginx.conf": {
+                    "content": "it's a trap",
+                    "mode": "000644"
+                }
+            }
+        }
+    },
+    "app": {
+        "name": "tango",
+        "port": 8080,
+        "debug": false,
+        "hosts": [ "a", "b" ]
+    }
+}
+`
+
+func TestValueDottedKey(t *testing.T) {
+	if v, err := Value(outputJson, "AWS::CloudFormation::Init.config.files./etc/nginx/nginx.conf.mode"); err != nil {
+		t.Error(err)
+	} else if v != "000644" {
+		t.Errorf("%v != 000644", v)
+	}
+
+	if v, err := Value(outputJson, "app.hosts.1"); err != nil {
+		t.Error(err)
+	} else if v != "b" {
+		t.Errorf("%v != b", v)
+	}
+
+	if _, err := Value(outputJson, "app.pants"); err == nil {
+		t.Errorf("missing keys should be an error")
+	}
+}
+
+func TestOutputFormats(t *testing.T) {
+	tests := []struct{ key, format, want string }{
+		{"app.name", "json", `"tango"`},
+		{"app.name", "raw", `tango`},
+		{"app.hosts", "compact", `["a","b"]`},
+		{"app.hosts", "raw", `["a","b"]`},
+		{"app.hosts", "yaml", "- a\n- b"},
+		{"app", "env", "DEBUG=false\nHOSTS_0=a\nHOSTS_1=b\nNAME=tango\nPORT=8080"},
+		{"AWS::CloudFormation::Init.config.files", "env", `_ETC_NGINX_NGINX_CONF_CONTENT='it'\''s a trap'` + "\n" + `_ETC_NGINX_NGINX_CONF_MODE=000644`},
+	}
+
+	for _, test := range tests {
+		if out, err := Output(outputJson, test.key, test.format); err != nil {
+			t.Error(err)
+		} else if out != test.want {
+			t.Errorf("%v as %v: %v != %v", test.key, test.format, out, test.want)
+		}
+	}
+
+	if _, err := Output(outputJson, "app.name", "env"); err == nil {
+		t.Errorf("env output of a string should be an error")
+	}
+	if _, err := Output(outputJson, "", "pants"); err == nil {
+		t.Errorf("unknown formats should be an error")
+	}
+	for _, j := range []string{`{"a-b": "x", "a_b": "y"}`, `{"a": {"b": "x"}, "a_b": "y"}`, `{"a": ["x"], "A_0": "y"}`} {
+		if out, err := Output(j, "", "env"); err == nil {
+			t.Errorf("keys with the same env name should be an error: %v", out)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// What secrets are replaced with
+const Mask = "********"
+
+// Authentication keys that are always masked
+var authenticationSecrets = []string{"password", "secretkey", "accesskeyid"}
+
+// Redact masks secrets in metadata so it can be logged or persisted: the
+// password, secretKey and accessKeyId of every AWS::CloudFormation::Authentication
+// entry, and any value (anywhere) whose key matches one of patterns. Patterns
+// are case-insensitive shell globs, e.g. "*token*".
+func Redact(metadata string, patterns []string) (string, error) {
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(metadata), &v); err != nil {
+		return "", err
+	}
+
+	lower := make([]string, len(patterns))
+	for i, p := range patterns {
+		lower[i] = strings.ToLower(p)
+		if _, err := path.Match(lower[i], ""); err != nil {
+			return "", fmt.Errorf("Invalid redaction pattern %q: %v", p, err)
+		}
+	}
+
+	if auths, ok := v["AWS::CloudFormation::Authentication"].(map[string]interface{}); ok {
+		for _, auth := range auths {
+			if auth, ok := auth.(map[string]interface{}); ok {
+				for k := range auth {
+					if contains(authenticationSecrets, strings.ToLower(k)) {
+						auth[k] = Mask
+					}
+				}
+			}
+		}
+	}
+
+	if len(lower) > 0 {
+		redact(v, lower)
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func redact(v interface{}, patterns []string) {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for k, child := range node {
+			if matches(patterns, strings.ToLower(k)) {
+				node[k] = Mask
+			} else {
+				redact(child, patterns)
+			}
+		}
+	case []interface{}:
+		for _, child := range node {
+			redact(child, patterns)
+		}
+	}
+}
+
+func matches(patterns []string, key string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metadata
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/jdub/cfn-init-tools/config"
+)
+
+// Session returns an AWS session for the configured region and credentials,
+// assuming --assume-role-arn if given
+func Session(conf config.Config) (*session.Session, error) {
+	client, err := HTTPClient(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := Credentials(conf, client)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := aws.NewConfig().WithRegion(conf.Region).WithHTTPClient(client)
+	if creds != nil {
+		cfg = cfg.WithCredentials(creds)
+	}
+	sess := session.New(cfg)
+
+	if conf.AssumeRoleArn == "" {
+		if conf.ExternalId != "" || conf.WebIdentityTokenFile != "" {
+			return nil, fmt.Errorf("You must pass --assume-role-arn with --external-id or --web-identity-token-file")
+		}
+		return sess, nil
+	}
+
+	// The role is assumed using the base session's credentials
+	return sess.Copy(aws.NewConfig().WithCredentials(AssumeRole(sess, conf))), nil
+}
@@ -0,0 +1,117 @@
+package metadata
+
+import (
+	"fmt"
+	"github.com/jdub/cfn-init-tools/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const assumeRoleResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIAASSUMED</AccessKeyId>
+      <SecretAccessKey>trousers</SecretAccessKey>
+      <SessionToken>pants-token</SessionToken>
+      <Expiration>2099-01-01T00:00:00Z</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::123456789012:assumed-role/cfn/cfn-init-tools</Arn>
+      <AssumedRoleId>AROAPANTS:cfn-init-tools</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleResult>
+  <ResponseMetadata><RequestId>pants</RequestId></ResponseMetadata>
+</AssumeRoleResponse>`
+
+const describeStackResourceResponse = `<DescribeStackResourceResponse xmlns="http://cloudformation.amazonaws.com/doc/2010-05-15/">
+  <DescribeStackResourceResult>
+    <StackResourceDetail>
+      <StackName>pants</StackName>
+      <LogicalResourceId>Web</LogicalResourceId>
+      <Metadata>{"pants":"trousers"}</Metadata>
+    </StackResourceDetail>
+  </DescribeStackResourceResult>
+  <ResponseMetadata><RequestId>pants</RequestId></ResponseMetadata>
+</DescribeStackResourceResponse>`
+
+// The access key a request was signed with
+func signedWith(req *http.Request) string {
+	auth := req.Header.Get("Authorization")
+	if i := strings.Index(auth, "Credential="); i != -1 {
+		return strings.SplitN(auth[i+len("Credential="):], "/", 2)[0]
+	}
+	return ""
+}
+
+func TestSessionAssumeRole(t *testing.T) {
+	var assumed bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		switch req.Form.Get("Action") {
+		case "AssumeRole":
+			// STS is called with the base credentials
+			if key := signedWith(req); key != "AKIDBASE" {
+				t.Errorf("AssumeRole signed with %q", key)
+			}
+			if arn := req.Form.Get("RoleArn"); arn != "arn:aws:iam::123456789012:role/cfn" {
+				t.Errorf("RoleArn %q", arn)
+			}
+			if id := req.Form.Get("ExternalId"); id != "pants" {
+				t.Errorf("ExternalId %q", id)
+			}
+			if name := req.Form.Get("RoleSessionName"); name != RoleSessionName {
+				t.Errorf("RoleSessionName %q", name)
+			}
+			assumed = true
+			fmt.Fprint(w, assumeRoleResponse)
+
+		case "DescribeStackResource":
+			// CloudFormation is called with the assumed role's credentials
+			if key := signedWith(req); key != "ASIAASSUMED" {
+				t.Errorf("DescribeStackResource signed with %q", key)
+			}
+			if token := req.Header.Get("X-Amz-Security-Token"); token != "pants-token" {
+				t.Errorf("session token %q", token)
+			}
+			fmt.Fprint(w, describeStackResourceResponse)
+
+		default:
+			http.Error(w, "unexpected action", http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	conf := config.Config{
+		Region:        "us-east-1",
+		Stack:         "pants",
+		Resource:      "Web",
+		Url:           server.URL,
+		AccessKey:     "AKIDBASE",
+		SecretKey:     "shorts",
+		AssumeRoleArn: "arn:aws:iam::123456789012:role/cfn",
+		ExternalId:    "pants",
+		StsUrl:        server.URL,
+	}
+	metadata, err := (&stackSource{conf}).describe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata != `{"pants":"trousers"}` {
+		t.Errorf("%q", metadata)
+	}
+	if !assumed {
+		t.Error("role was not assumed")
+	}
+}
+
+func TestSessionAssumeRoleOptions(t *testing.T) {
+	// No, Mr. Bond, I expect you to die!
+	if _, err := Session(config.Config{Region: "us-east-1", ExternalId: "pants"}); err == nil {
+		t.Errorf("--external-id without --assume-role-arn should be an error")
+	}
+	if _, err := Session(config.Config{Region: "us-east-1", WebIdentityTokenFile: "/pants"}); err == nil {
+		t.Errorf("--web-identity-token-file without --assume-role-arn should be an error")
+	}
+}
@@ -0,0 +1,351 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metadata
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/jdub/cfn-init-tools/config"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// A Source provides raw metadata as JSON
+type Source interface {
+	Fetch() (string, error)
+}
+
+// NewSource picks the metadata source: --source, --local, or the stack
+// resource. --source is a URL, with the scheme selecting the source:
+//
+//	file:///path/to/metadata.json  (or just a path)
+//	https://example.com/metadata.json
+//	s3://bucket/key
+//	imds://user-data
+//	-  (stdin)
+func NewSource(conf config.Config) (Source, error) {
+	switch {
+	case conf.Source != "" && len(conf.Local) > 0:
+		return nil, fmt.Errorf("You must pass only one of --source and --local")
+	case len(conf.Local) == 1:
+		return localSource(conf, conf.Local[0]), nil
+	case len(conf.Local) > 1:
+		m := &mergeSource{conf: conf}
+		for _, name := range conf.Local {
+			m.sources = append(m.sources, localSource(conf, name))
+		}
+		return m, nil
+	case conf.Source == "":
+		if conf.Stack == "" || conf.Resource == "" {
+			return nil, fmt.Errorf("You must pass --local, --source, or --stack and --resource")
+		}
+		return &stackSource{conf}, nil
+	case conf.Source == "-":
+		return &stdinSource{conf, os.Stdin}, nil
+	}
+
+	u, err := url.Parse(conf.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "":
+		return &fileSource{conf, conf.Source}, nil
+	case "file":
+		return &fileSource{conf, u.Path}, nil
+	case "http", "https":
+		return &httpSource{conf, u.String()}, nil
+	case "s3":
+		if u.Host == "" || strings.TrimPrefix(u.Path, "/") == "" {
+			return nil, fmt.Errorf("invalid S3 source url, expected s3://bucket/key: %v", conf.Source)
+		}
+		return &s3Source{conf, u.Host, strings.TrimPrefix(u.Path, "/")}, nil
+	case "imds":
+		if u.Host != "user-data" {
+			return nil, fmt.Errorf("invalid IMDS source url, expected imds://user-data: %v", conf.Source)
+		}
+		return &userDataSource{conf}, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported metadata source: %v", conf.Source)
+}
+
+func localSource(conf config.Config, name string) Source {
+	if name == "-" {
+		return &stdinSource{conf, os.Stdin}
+	}
+	return &fileSource{conf, name}
+}
+
+// Metadata from anywhere but CloudFormation may be YAML, a whole template, or
+// contain intrinsic functions, so it's prepared the same way as local files
+func prepare(conf config.Config, name string, b []byte) (metadata string, err error) {
+	metadata = string(b)
+	if IsYaml(name, b) {
+		if metadata, err = Yaml(b, conf.TolerantYaml); err != nil {
+			return "", err
+		}
+	}
+
+	// Local copies of templates may still contain intrinsic functions
+	params := PseudoParameters(conf)
+	if conf.Parameters != "" {
+		p, err := ReadParameters(conf.Parameters)
+		if err != nil {
+			return "", err
+		}
+		for k, v := range p {
+			params[k] = v
+		}
+	}
+
+	if IsTemplate(metadata) {
+		return ResolveTemplate(metadata, conf.Resource, params)
+	} else if conf.Parameters != "" {
+		return Resolve(metadata, params)
+	}
+
+	return metadata, nil
+}
+
+// Metadata attached to a CloudFormation stack resource
+type stackSource struct {
+	conf config.Config
+}
+
+func (s *stackSource) Fetch() (metadata string, err error) {
+	conf := s.conf
+
+	if conf.MaxAge > 0 {
+		if c, err := ReadCache(conf); err == nil && time.Since(c.Fetched) < conf.MaxAge {
+			return c.Metadata, nil
+		}
+	}
+
+	if metadata, err = s.describe(); err != nil {
+		// Offline mode: fall back to the last known metadata
+		if conf.Cached {
+			if c, cerr := ReadCache(conf); cerr == nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\nUsing cached metadata from %v\n", err, c.Fetched.Local().Format(time.RFC1123))
+				return c.Metadata, nil
+			}
+		}
+		return "", err
+	}
+
+	// The cache holds unredacted metadata, so it's only kept when requested,
+	// and is best effort; not every user can write to the data directory
+	if conf.Cached || conf.MaxAge > 0 {
+		if changed, err := WriteCache(conf, metadata); err == nil && changed {
+			fmt.Fprintf(os.Stderr, "Metadata for %v in stack %v is new or changed since it was last cached\n", conf.Resource, conf.Stack)
+		}
+	}
+
+	return metadata, nil
+}
+
+func (s *stackSource) describe() (metadata string, err error) {
+	conf := s.conf
+
+	endpoint := ""
+	if conf.Url != "" {
+		if u, err := url.Parse(conf.Url); err != nil {
+			return "", err
+		} else if u.Scheme == "" {
+			return "", fmt.Errorf("invalid endpoint url: %v", conf.Url)
+		} else {
+			endpoint = u.String()
+		}
+	}
+
+	sess, err := Session(conf)
+	if err != nil {
+		return "", err
+	}
+
+	svc := cloudformation.New(sess, &aws.Config{
+		Endpoint: aws.String(endpoint),
+	})
+
+	params := &cloudformation.DescribeStackResourceInput{
+		LogicalResourceId: aws.String(conf.Resource),
+		StackName:         aws.String(conf.Stack),
+	}
+
+	res, err := svc.DescribeStackResource(params)
+	if err != nil {
+		return "", err
+	}
+
+	return *res.StackResourceDetail.Metadata, nil
+}
+
+// Multiple metadata documents, deep-merged in order
+type mergeSource struct {
+	conf    config.Config
+	sources []Source
+}
+
+func (s *mergeSource) Fetch() (string, error) {
+	docs := make([]string, len(s.sources))
+	for i, src := range s.sources {
+		var err error
+		if docs[i], err = src.Fetch(); err != nil {
+			return "", err
+		}
+	}
+
+	merged, warnings, err := Merge(docs, s.conf.MergeConfigSets)
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", w)
+	}
+	return merged, err
+}
+
+// A local metadata or template file
+type fileSource struct {
+	conf config.Config
+	name string
+}
+
+func (s *fileSource) Fetch() (string, error) {
+	b, err := ioutil.ReadFile(s.name)
+	if err != nil {
+		return "", err
+	}
+	return prepare(s.conf, s.name, b)
+}
+
+// The most metadata that will be read from stdin, which is plenty for even
+// the largest template CloudFormation accepts
+const MaxStdinSize = 1 << 20
+
+// Metadata piped to stdin
+type stdinSource struct {
+	conf  config.Config
+	stdin io.Reader
+}
+
+func (s *stdinSource) Fetch() (string, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(s.stdin, MaxStdinSize+1))
+	if err != nil {
+		return "", err
+	}
+
+	if len(b) > MaxStdinSize {
+		return "", fmt.Errorf("Metadata on stdin is larger than %d bytes", MaxStdinSize)
+	} else if len(bytes.TrimSpace(b)) == 0 {
+		return "", fmt.Errorf("No metadata on stdin")
+	}
+
+	return prepare(s.conf, "", b)
+}
+
+// Metadata served over HTTP(S)
+type httpSource struct {
+	conf config.Config
+	url  string
+}
+
+func (s *httpSource) Fetch() (string, error) {
+	client, err := HTTPClient(s.conf)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := client.Get(s.url)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return "", fmt.Errorf("Could not fetch %v: %v", s.url, res.Status)
+	}
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	u, _ := url.Parse(s.url)
+	return prepare(s.conf, path.Base(u.Path), b)
+}
+
+// Metadata stored as an S3 object
+type s3Source struct {
+	conf   config.Config
+	bucket string
+	key    string
+}
+
+func (s *s3Source) Fetch() (string, error) {
+	sess, err := Session(s.conf)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := s3.New(sess).GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	return prepare(s.conf, path.Base(s.key), b)
+}
+
+// Metadata provided as EC2 user data
+type userDataSource struct {
+	conf config.Config
+}
+
+func (s *userDataSource) Fetch() (string, error) {
+	client, err := HTTPClient(s.conf)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := ec2metadata.New(session.New(aws.NewConfig().WithHTTPClient(client))).GetUserData()
+	if err != nil {
+		return "", err
+	}
+
+	return prepare(s.conf, "", []byte(data))
+}
@@ -0,0 +1,126 @@
+package metadata
+
+import (
+	"bytes"
+	"github.com/jdub/cfn-init-tools/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewSource(t *testing.T) {
+	sources := map[string]Source{
+		"-":                     &stdinSource{},
+		"metadata.json":         &fileSource{},
+		"file:///metadata.json": &fileSource{},
+		"https://example.com/m": &httpSource{},
+		"s3://bucket/metadata":  &s3Source{},
+		"imds://user-data":      &userDataSource{},
+	}
+	for url, want := range sources {
+		src, err := NewSource(config.Config{Source: url})
+		if err != nil {
+			t.Errorf("%v: %v", url, err)
+		} else if got, want := typeName(src), typeName(want); got != want {
+			t.Errorf("%v: %v != %v", url, got, want)
+		}
+	}
+
+	if src, err := NewSource(config.Config{Local: []string{"-"}}); err != nil {
+		t.Error(err)
+	} else if _, ok := src.(*stdinSource); !ok {
+		t.Errorf("--local - should read stdin")
+	}
+
+	if src, err := NewSource(config.Config{Stack: "tango", Resource: "Web"}); err != nil {
+		t.Error(err)
+	} else if _, ok := src.(*stackSource); !ok {
+		t.Errorf("--stack and --resource should use the stack resource")
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	for _, conf := range []config.Config{
+		{},
+		{Stack: "tango"},
+		{Source: "-", Local: []string{"metadata.json"}},
+		{Source: "ftp://example.com/metadata.json"},
+		{Source: "s3://bucket"},
+		{Source: "imds://meta-data"},
+	} {
+		if _, err := NewSource(conf); err == nil {
+			t.Errorf("%+v should be an error", conf)
+		}
+	}
+}
+
+func typeName(src Source) string {
+	switch src.(type) {
+	case *stdinSource:
+		return "stdin"
+	case *fileSource:
+		return "file"
+	case *httpSource:
+		return "http"
+	case *s3Source:
+		return "s3"
+	case *userDataSource:
+		return "user-data"
+	}
+	return "unknown"
+}
+
+func TestHttpSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata.yml":
+			w.Write([]byte("AWS::CloudFormation::Init:\n  config: {}\n"))
+		case "/stalled":
+			// Start responding, then never finish
+			w.Write([]byte("AWS::CloudFormation::Init:\n"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	j, err := Fetch(config.Config{Source: srv.URL + "/metadata.yml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, err := Parse(j); err != nil {
+		t.Error(err)
+	} else if m.Init.Configs["config"] == nil {
+		t.Errorf("YAML served over HTTP not converted: %v", j)
+	}
+
+	if _, err := Fetch(config.Config{Source: srv.URL + "/pants"}); err == nil {
+		t.Errorf("a 404 should be an error")
+	}
+	if _, err := Fetch(config.Config{Source: srv.URL + "/stalled", HttpTimeout: 100 * time.Millisecond}); err == nil {
+		t.Errorf("a stalled response should time out")
+	}
+}
+
+func TestStdinSource(t *testing.T) {
+	src := &stdinSource{stdin: strings.NewReader(`{"AWS::CloudFormation::Init": {"config": {}}}`)}
+	if j, err := src.Fetch(); err != nil {
+		t.Error(err)
+	} else if _, err := Parse(j); err != nil {
+		t.Error(err)
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	src = &stdinSource{stdin: strings.NewReader(" \n")}
+	if _, err := src.Fetch(); err == nil {
+		t.Errorf("empty stdin should be an error")
+	}
+
+	src = &stdinSource{stdin: bytes.NewReader(make([]byte, MaxStdinSize+1))}
+	if _, err := src.Fetch(); err == nil {
+		t.Errorf("oversized stdin should be an error")
+	}
+}
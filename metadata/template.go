@@ -0,0 +1,259 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jdub/cfn-init-tools/config"
+	"reflect"
+	"strings"
+)
+
+// Just enough of a CloudFormation template to evaluate resource metadata
+type Template struct {
+	Parameters map[string]*TemplateParameter                `json:"Parameters"`
+	Mappings   map[string]map[string]map[string]interface{} `json:"Mappings"`
+	Conditions map[string]interface{}                       `json:"Conditions"`
+	Resources  map[string]*TemplateResource                 `json:"Resources"`
+}
+
+type TemplateParameter struct {
+	Type    string      `json:"Type"`
+	Default interface{} `json:"Default"`
+}
+
+type TemplateResource struct {
+	Type     string      `json:"Type"`
+	Metadata interface{} `json:"Metadata"`
+}
+
+// IsTemplate reports whether a local file holds a whole template rather than
+// the metadata of a single resource
+func IsTemplate(metadata string) bool {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(metadata), &top); err != nil {
+		return false
+	}
+	_, resources := top["Resources"]
+	_, init := top["AWS::CloudFormation::Init"]
+	return resources && !init
+}
+
+// PseudoParameters returns local stand-ins for the AWS:: pseudo parameters,
+// any of which may be overridden in a parameters file. AWS::AccountId, and
+// AWS::StackId which contains it, are only known with --account-id.
+func PseudoParameters(conf config.Config) Parameters {
+	stack := conf.Stack
+	if stack == "" {
+		stack = "local"
+	}
+
+	params := Parameters{
+		"AWS::NotificationARNs": "",
+		"AWS::Partition":        "aws",
+		"AWS::Region":           conf.Region,
+		"AWS::StackName":        stack,
+		"AWS::URLSuffix":        "amazonaws.com",
+	}
+	if conf.AccountId != "" {
+		params["AWS::AccountId"] = conf.AccountId
+		params["AWS::StackId"] = fmt.Sprintf("arn:aws:cloudformation:%v:%v:stack/%v/00000000-0000-0000-0000-000000000000", conf.Region, conf.AccountId, stack)
+	}
+	return params
+}
+
+// ResolveTemplate returns the metadata of resource in template, evaluated
+// with a local implementation of the intrinsic functions. In addition to
+// those handled by Resolve, it supports parameter defaults, Fn::If with the
+// template's Conditions, Fn::FindInMap, and stubs Fn::GetAtt (and Ref to
+// other resources) with "Resource.Attribute" (or "Resource") unless a value
+// is given in params.
+func ResolveTemplate(template string, resource string, params Parameters) (string, error) {
+	var t Template
+	if err := json.Unmarshal([]byte(template), &t); err != nil {
+		return "", err
+	}
+
+	if resource == "" {
+		return "", fmt.Errorf("You must pass --resource to evaluate a template")
+	}
+	r, ok := t.Resources[resource]
+	if !ok {
+		return "", fmt.Errorf("Resource %v not found in template", resource)
+	}
+	if r.Metadata == nil {
+		return "", fmt.Errorf("Resource %v has no Metadata", resource)
+	}
+
+	// Given parameters take precedence over template defaults
+	all := make(Parameters)
+	for name, p := range t.Parameters {
+		if p.Default != nil {
+			if all[name], ok = p.Default.(string); !ok {
+				all[name] = fmt.Sprint(p.Default)
+			}
+		}
+	}
+	for name, v := range params {
+		all[name] = v
+	}
+
+	e := &evaluator{params: all, template: &t, conditions: make(map[string]bool), evaluating: make(map[string]bool)}
+	v, err := e.eval(r.Metadata)
+	if err != nil {
+		return "", fmt.Errorf("%v metadata: %v", resource, err)
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func (t *Template) isList(name string) bool {
+	p, ok := t.Parameters[name]
+	return ok && (p.Type == "CommaDelimitedList" || strings.HasPrefix(p.Type, "List<"))
+}
+
+// Functions that need the rest of the template
+func (e *evaluator) callTemplate(fn string, arg interface{}) (v interface{}, ok bool, err error) {
+	switch fn {
+	case "Fn::GetAtt":
+		args, isList := arg.([]interface{})
+		if s, isString := arg.(string); isString {
+			args, isList = []interface{}{s}, true
+			if parts := strings.SplitN(s, ".", 2); len(parts) == 2 {
+				args = []interface{}{parts[0], parts[1]}
+			}
+		}
+		if !isList || len(args) != 2 {
+			return nil, true, fmt.Errorf("Fn::GetAtt: expected [resource, attribute]")
+		}
+		parts := make([]string, 2)
+		for i := range parts {
+			if parts[i], err = scalar(args[i]); err != nil {
+				return nil, true, fmt.Errorf("Fn::GetAtt: %v", err)
+			}
+		}
+		return e.getAtt(parts), true, nil
+
+	case "Fn::FindInMap":
+		args, isList := arg.([]interface{})
+		if !isList || len(args) != 3 {
+			return nil, true, fmt.Errorf("Fn::FindInMap: expected [map, top-level key, second-level key]")
+		}
+		keys := make([]string, 3)
+		for i := range keys {
+			if keys[i], err = scalar(args[i]); err != nil {
+				return nil, true, fmt.Errorf("Fn::FindInMap: %v", err)
+			}
+		}
+		if v, found := e.template.Mappings[keys[0]][keys[1]][keys[2]]; found {
+			return v, true, nil
+		}
+		return nil, true, fmt.Errorf("Fn::FindInMap: %v not found", strings.Join(keys, "."))
+
+	case "Condition":
+		name, isString := arg.(string)
+		if !isString {
+			return nil, true, fmt.Errorf("Condition: expected a name, got %v", arg)
+		}
+		v, err = e.condition(name)
+		return v, true, err
+
+	case "Fn::Equals":
+		args, isList := arg.([]interface{})
+		if !isList || len(args) != 2 {
+			return nil, true, fmt.Errorf("Fn::Equals: expected [value, value]")
+		}
+		a, aerr := scalar(args[0])
+		b, berr := scalar(args[1])
+		if aerr == nil && berr == nil {
+			return a == b, true, nil
+		}
+		return reflect.DeepEqual(args[0], args[1]), true, nil
+
+	case "Fn::Not", "Fn::And", "Fn::Or":
+		args, isList := arg.([]interface{})
+		if !isList || len(args) == 0 || fn == "Fn::Not" && len(args) != 1 {
+			return nil, true, fmt.Errorf("%v: expected a list of conditions", fn)
+		}
+		result := fn == "Fn::And"
+		for _, a := range args {
+			b, isBool := a.(bool)
+			if !isBool {
+				return nil, true, fmt.Errorf("%v: expected a condition, got %v", fn, a)
+			}
+			switch fn {
+			case "Fn::Not":
+				result = !b
+			case "Fn::And":
+				result = result && b
+			case "Fn::Or":
+				result = result || b
+			}
+		}
+		return result, true, nil
+	}
+
+	return nil, false, nil
+}
+
+func (e *evaluator) getAtt(parts []string) interface{} {
+	name := strings.Join(parts, ".")
+	if v, ok := e.params[name]; ok {
+		return v
+	}
+	return name
+}
+
+func (e *evaluator) condition(name string) (bool, error) {
+	if v, ok := e.conditions[name]; ok {
+		return v, nil
+	}
+
+	expr, ok := e.template.Conditions[name]
+	if !ok {
+		return false, fmt.Errorf("Condition %v not found in template", name)
+	}
+
+	// A condition that refers back to itself would never finish
+	if e.evaluating[name] {
+		return false, fmt.Errorf("Circular condition %v", name)
+	}
+	e.evaluating[name] = true
+	defer delete(e.evaluating, name)
+
+	v, err := e.eval(expr)
+	if err != nil {
+		return false, fmt.Errorf("Condition %v: %v", name, err)
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("Condition %v did not evaluate to true or false", name)
+	}
+
+	e.conditions[name] = b
+	return b, nil
+}
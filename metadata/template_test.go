@@ -0,0 +1,147 @@
+package metadata
+
+import (
+	"github.com/jdub/cfn-init-tools/config"
+	"strings"
+	"testing"
+)
+
+const templateJson = `
+{
+    "Parameters": {
+        "Env": { "Type": "String", "Default": "dev" },
+        "Zones": { "Type": "CommaDelimitedList", "Default": "a, b" }
+    },
+    "Mappings": {
+        "Ports": { "dev": { "Http": 8080 }, "prod": { "Http": 80 } }
+    },
+    "Conditions": {
+        "IsProd": { "Fn::Equals": [ { "Ref": "Env" }, "prod" ] },
+        "IsDev": { "Fn::Not": [ { "Condition": "IsProd" } ] }
+    },
+    "Resources": {
+        "Bucket": { "Type": "AWS::S3::Bucket" },
+        "Web": {
+            "Type": "AWS::EC2::Instance",
+            "Metadata": {
+                "AWS::CloudFormation::Init": {
+                    "config": {
+                        "files": {
+                            "/etc/app.conf": {
+                                "content": { "Fn::Sub": "region=${AWS::Region}\nstack=${AWS::StackName}\nbucket=${Bucket}\nhost=${Bucket.DomainName}\n" },
+                                "mode": { "Fn::If": [ "IsProd", "000600", { "Ref": "AWS::NoValue" } ] }
+                            },
+                            "/etc/port": {
+                                "content": { "Fn::Join": [ ",", [ { "Fn::FindInMap": [ "Ports", { "Ref": "Env" }, "Http" ] }, { "Fn::Select": [ 1, { "Ref": "Zones" } ] } ] ] }
+                            },
+                            "/etc/debug": {
+                                "content": { "Fn::If": [ "IsDev", "yes", { "Ref": "Undefined" } ] }
+                            }
+                        }
+                    }
+                }
+            }
+        }
+    }
+}
+`
+
+func TestIsTemplate(t *testing.T) {
+	if !IsTemplate(templateJson) {
+		t.Errorf("template not detected")
+	}
+	if IsTemplate(`{"AWS::CloudFormation::Init": {}}`) {
+		t.Errorf("metadata detected as a template")
+	}
+}
+
+func TestResolveTemplate(t *testing.T) {
+	params := PseudoParameters(config.Config{Region: "ap-southeast-2"})
+
+	j, err := ResolveTemplate(templateJson, "Web", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := Parse(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := m.Init.Configs["config"].Files
+	want := "region=ap-southeast-2\nstack=local\nbucket=Bucket\nhost=Bucket.DomainName\n"
+	if c := files["/etc/app.conf"].Content; c != want {
+		t.Errorf("%q != %q", c, want)
+	}
+	if mode := files["/etc/app.conf"].Mode; mode != "" {
+		t.Errorf("AWS::NoValue should remove mode, got %q", mode)
+	}
+	if c := files["/etc/port"].Content; c != "8080,b" {
+		t.Errorf("%q != %q", c, "8080,b")
+	}
+	if c := files["/etc/debug"].Content; c != "yes" {
+		t.Errorf("%q != %q", c, "yes")
+	}
+
+	params["Env"] = "prod"
+	params["Bucket.DomainName"] = "pants.s3.amazonaws.com"
+	if j, err := ResolveTemplate(templateJson, "Web", params); err == nil {
+		t.Errorf("the untaken Fn::If branch should now fail: %v", j)
+	}
+
+	if _, err := ResolveTemplate(templateJson, "Pants", params); err == nil {
+		t.Errorf("missing resources should be an error")
+	}
+}
+
+func TestCircularCondition(t *testing.T) {
+	template := `{
+    "Conditions": {
+        "A": { "Fn::Not": [ { "Condition": "B" } ] },
+        "B": { "Fn::Or": [ { "Condition": "A" }, { "Condition": "C" } ] },
+        "C": { "Fn::Equals": [ "a", "a" ] },
+        "D": { "Fn::And": [ { "Condition": "C" }, { "Condition": "C" } ] }
+    },
+    "Resources": {
+        "Good": {
+            "Type": "AWS::EC2::Instance",
+            "Metadata": { "debug": { "Fn::If": [ "D", "yes", "no" ] } }
+        },
+        "Bad": {
+            "Type": "AWS::EC2::Instance",
+            "Metadata": { "debug": { "Fn::If": [ "A", "yes", "no" ] } }
+        }
+    }
+}`
+
+	// A condition used twice isn't circular
+	if j, err := ResolveTemplate(template, "Good", nil); err != nil {
+		t.Error(err)
+	} else if j != `{"debug":"yes"}` {
+		t.Errorf("%v != %v", j, `{"debug":"yes"}`)
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	if _, err := ResolveTemplate(template, "Bad", nil); err == nil || !strings.Contains(err.Error(), "Circular condition A") {
+		t.Errorf("circular conditions should be an error: %v", err)
+	}
+}
+
+func TestPseudoParametersAccountId(t *testing.T) {
+	metadata := `{"arn": {"Fn::Sub": "arn:aws:iam::${AWS::AccountId}:role/pants"}}`
+
+	params := PseudoParameters(config.Config{Region: "us-east-1", AccountId: "210987654321"})
+	if j, err := Resolve(metadata, params); err != nil {
+		t.Error(err)
+	} else if j != `{"arn":"arn:aws:iam::210987654321:role/pants"}` {
+		t.Errorf("%v", j)
+	}
+	if id := params["AWS::StackId"]; !strings.Contains(id, ":210987654321:stack/local/") {
+		t.Errorf("%v", id)
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	if j, err := Resolve(metadata, PseudoParameters(config.Config{Region: "us-east-1"})); err == nil {
+		t.Errorf("AWS::AccountId without --account-id should be an error: %v", j)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"path/filepath"
+	"strings"
+)
+
+// IsYaml guesses whether a local metadata file is YAML, first by its
+// extension and then by whether the content looks like a JSON object
+func IsYaml(name string, content []byte) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		return true
+	case ".json":
+		return false
+	}
+
+	s := strings.TrimSpace(string(content))
+	return s != "" && !strings.HasPrefix(s, "{")
+}
+
+// Yaml converts a YAML document to the equivalent JSON, so it can be handled
+// exactly like metadata fetched from CloudFormation.
+//
+// CloudFormation short-form tags (!Ref, !Sub, !GetAtt, ...) are rejected
+// unless tolerant is set, in which case they are rewritten to their long
+// form, e.g. `!Ref Foo` becomes `{"Ref": "Foo"}`.
+func Yaml(content []byte, tolerant bool) (string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return "", err
+	}
+
+	if len(doc.Content) == 0 {
+		return "", fmt.Errorf("YAML document is empty")
+	}
+
+	v, err := yamlValue(doc.Content[0], tolerant)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func yamlValue(n *yaml.Node, tolerant bool) (interface{}, error) {
+	// CloudFormation doesn't accept anchors and aliases, and following them
+	// risks cycles (a: &x {b: *x}) and exponential expansion
+	if n.Kind == yaml.AliasNode {
+		return nil, fmt.Errorf("line %d: YAML aliases are not allowed", n.Line)
+	}
+
+	if fn := shortForm(n.Tag); fn != "" {
+		if !tolerant {
+			return nil, fmt.Errorf("line %d: CloudFormation short-form tag %s is not allowed; use the long form or pass --tolerant-yaml", n.Line, n.Tag)
+		}
+		return yamlIntrinsic(n, fn, tolerant)
+	}
+
+	switch n.Kind {
+	case yaml.MappingNode:
+		m := make(map[string]interface{}, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, err := yamlValue(n.Content[i], tolerant)
+			if err != nil {
+				return nil, err
+			}
+			v, err := yamlValue(n.Content[i+1], tolerant)
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(k)] = v
+		}
+		return m, nil
+
+	case yaml.SequenceNode:
+		s := make([]interface{}, 0, len(n.Content))
+		for _, c := range n.Content {
+			v, err := yamlValue(c, tolerant)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, v)
+		}
+		return s, nil
+
+	case yaml.ScalarNode:
+		return yamlScalar(n)
+	}
+
+	return nil, fmt.Errorf("line %d: unsupported YAML node", n.Line)
+}
+
+func yamlScalar(n *yaml.Node) (interface{}, error) {
+	switch n.ShortTag() {
+	case "!!null":
+		return nil, nil
+	case "!!bool":
+		var b bool
+		err := n.Decode(&b)
+		return b, err
+	case "!!int", "!!float":
+		// Keep numbers exactly as written, like encoding/json would, but
+		// leave anything JSON can't represent (e.g. 000644) as a string
+		if json.Valid([]byte(n.Value)) {
+			return json.Number(n.Value), nil
+		}
+		return n.Value, nil
+	}
+	return n.Value, nil
+}
+
+// Returns the long-form function name for a CloudFormation short-form tag
+func shortForm(tag string) string {
+	switch tag {
+	case "!Ref", "!Condition":
+		return tag[1:]
+	case "!Base64", "!Cidr", "!FindInMap", "!GetAtt", "!GetAZs", "!ImportValue",
+		"!Join", "!Select", "!Split", "!Sub", "!Transform",
+		"!And", "!Equals", "!If", "!Not", "!Or":
+		return "Fn::" + tag[1:]
+	}
+	return ""
+}
+
+func yamlIntrinsic(n *yaml.Node, fn string, tolerant bool) (interface{}, error) {
+	// Strip the tag so the argument is decoded as plain YAML
+	arg := *n
+	arg.Tag = ""
+	if arg.Kind == yaml.ScalarNode {
+		arg.Tag = "!!str"
+	}
+
+	v, err := yamlValue(&arg, tolerant)
+	if err != nil {
+		return nil, err
+	}
+
+	// !GetAtt Resource.Attribute is shorthand for [Resource, Attribute]
+	if s, ok := v.(string); ok && fn == "Fn::GetAtt" {
+		parts := strings.SplitN(s, ".", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: invalid !GetAtt %q", n.Line, s)
+		}
+		v = []interface{}{parts[0], parts[1]}
+	}
+
+	return map[string]interface{}{fn: v}, nil
+}
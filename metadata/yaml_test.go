@@ -0,0 +1,91 @@
+package metadata
+
+import (
+	"testing"
+)
+
+func TestIsYaml(t *testing.T) {
+	if !IsYaml("metadata.yml", []byte(`{}`)) {
+		t.Errorf("*.yml should always be treated as YAML")
+	}
+	if IsYaml("metadata.json", []byte(`foo: bar`)) {
+		t.Errorf("*.json should never be treated as YAML")
+	}
+	if IsYaml("metadata", []byte("  \n{}")) {
+		t.Errorf("a JSON object should not be sniffed as YAML")
+	}
+	if !IsYaml("metadata", []byte("foo: bar")) {
+		t.Errorf("YAML content should be sniffed as YAML")
+	}
+}
+
+func TestYaml(t *testing.T) {
+	yaml := `
+AWS::CloudFormation::Init:
+  config:
+    files:
+      /etc/motd:
+        content: hello
+        mode: "000644"
+    commands:
+      ps afx:
+        command: ps afx
+        ignoreErrors: true
+`
+	j, err := Yaml([]byte(yaml), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m, err := Parse(j); err != nil {
+		t.Error(err)
+	} else {
+		if f := m.Init.Configs["config"].Files["/etc/motd"]; f.Mode != "000644" || f.Content != "hello" {
+			t.Errorf("Files not converted correctly: %+v", f)
+		}
+		if c := m.Init.Configs["config"].Commands["ps afx"]; c.IgnoreErrors != true {
+			t.Errorf("Commands not converted correctly: %+v", c)
+		}
+	}
+}
+
+func TestYamlShortForm(t *testing.T) {
+	yaml := `
+AWS::CloudFormation::Init:
+  config:
+    files:
+      /etc/hosts:
+        content: !Sub "${AWS::StackName}"
+      /etc/bucket:
+        content: !GetAtt Bucket.DomainName
+`
+	// No, Mr. Bond, I expect you to die!
+	if _, err := Yaml([]byte(yaml), false); err == nil {
+		t.Errorf("short-form tags should be rejected unless tolerant")
+	}
+
+	j, err := Yaml([]byte(yaml), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"AWS::CloudFormation::Init":{"config":{"files":{"/etc/bucket":{"content":{"Fn::GetAtt":["Bucket","DomainName"]}},"/etc/hosts":{"content":{"Fn::Sub":"${AWS::StackName}"}}}}}}`
+	if j != want {
+		t.Errorf("%v != %v", j, want)
+	}
+}
+
+func TestYamlAliases(t *testing.T) {
+	docs := []string{
+		"a: &x {b: *x}\n",
+		"a: &a [1, 2]\nb: &b [*a, *a]\nc: [*b, *b]\n",
+		"base: &base {mode: '000644'}\nfile:\n  <<: *base\n",
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	for _, doc := range docs {
+		if j, err := Yaml([]byte(doc), true); err == nil {
+			t.Errorf("aliases should be rejected: %v", j)
+		}
+	}
+}
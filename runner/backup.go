@@ -0,0 +1,228 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jdub/cfn-init-tools/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The state of a file before a run changed it
+type Backup struct {
+	Path string `json:"path"`
+	// The file didn't exist, so rolling back removes it
+	Created bool        `json:"created,omitempty"`
+	Mode    os.FileMode `json:"mode,omitempty"`
+	Uid     int         `json:"uid"`
+	Gid     int         `json:"gid"`
+	Link    string      `json:"link,omitempty"`
+	// A copy of the content, relative to the run's backup directory
+	Copy string `json:"copy,omitempty"`
+}
+
+// BackupDir is where files changed by a run are backed up
+func BackupDir(dataDir string, runId string) string {
+	return filepath.Join(dataDir, "backups", runId)
+}
+
+// Backs up a file before the first time the run changes it, recording the
+// backup in the run's manifest straight away, so an interrupted run can
+// still be rolled back
+func (r *Runner) backup(path string) error {
+	for _, b := range r.backups {
+		if b.Path == path {
+			return nil
+		}
+	}
+
+	dir := BackupDir(r.Config.DataDir, r.Report.RunId)
+	if err := os.MkdirAll(filepath.Join(dir, "files"), config.DataDirMode); err != nil {
+		return err
+	}
+
+	b := &Backup{Path: path, Uid: -1, Gid: -1}
+	info, err := os.Lstat(path)
+	switch {
+	case os.IsNotExist(err):
+		b.Created = true
+	case err != nil:
+		return err
+	case info.Mode()&os.ModeSymlink != 0:
+		if b.Link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	case info.Mode().IsRegular():
+		b.Mode = info.Mode() & modeBits
+		b.Copy = filepath.Join("files", strconv.Itoa(len(r.backups)))
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, b.Copy), content, 0600); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Refusing to replace %v, which is not a regular file or symlink", path)
+	}
+	if info != nil {
+		b.Uid, b.Gid = fileOwner(info)
+	}
+
+	r.backups = append(r.backups, b)
+	return writeManifest(dir, r.backups)
+}
+
+func writeManifest(dir string, backups []*Backup) error {
+	j, err := json.MarshalIndent(backups, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Join(dir, "manifest.json")
+	tmp := name + ".tmp"
+	if err := ioutil.WriteFile(tmp, j, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// Backups returns the IDs of runs with backups, oldest first
+func Backups(dataDir string) ([]string, error) {
+	manifests, err := filepath.Glob(filepath.Join(dataDir, "backups", "*", "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]string, 0, len(manifests))
+	for _, m := range manifests {
+		runs = append(runs, filepath.Base(filepath.Dir(m)))
+	}
+	sort.Strings(runs)
+	return runs, nil
+}
+
+// PruneBackups removes the backups of runs which last changed a file before
+// before, returning the run IDs removed
+func PruneBackups(dataDir string, before time.Time) ([]string, error) {
+	runs, err := Backups(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, runId := range runs {
+		dir := BackupDir(dataDir, runId)
+		info, err := os.Stat(filepath.Join(dir, "manifest.json"))
+		if err != nil {
+			return removed, err
+		}
+		if !info.ModTime().Before(before) {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return removed, err
+		}
+		removed = append(removed, runId)
+	}
+	return removed, nil
+}
+
+// Rollback restores the files changed by a run, or the latest run with
+// backups, to their state before it. It returns the run ID and the paths
+// restored, in the reverse order they were changed.
+func Rollback(dataDir string, runId string) (string, []string, error) {
+	if runId == "" {
+		runs, err := Backups(dataDir)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(runs) == 0 {
+			return "", nil, fmt.Errorf("No backups found in %v", filepath.Join(dataDir, "backups"))
+		}
+		runId = runs[len(runs)-1]
+	}
+
+	if filepath.Base(runId) != runId {
+		return runId, nil, fmt.Errorf("Invalid run ID %v", runId)
+	}
+
+	dir := BackupDir(dataDir, runId)
+	j, err := ioutil.ReadFile(filepath.Join(dir, "manifest.json"))
+	if os.IsNotExist(err) {
+		return runId, nil, fmt.Errorf("No backups found for run %v", runId)
+	} else if err != nil {
+		return runId, nil, err
+	}
+
+	var backups []*Backup
+	if err := json.Unmarshal(j, &backups); err != nil {
+		return runId, nil, fmt.Errorf("Could not read backups for run %v: %v", runId, err)
+	}
+
+	var restored []string
+	for i := len(backups) - 1; i >= 0; i-- {
+		if err := restore(dir, backups[i]); err != nil {
+			return runId, restored, fmt.Errorf("Could not restore %v: %v", backups[i].Path, err)
+		}
+		restored = append(restored, backups[i].Path)
+	}
+	return runId, restored, nil
+}
+
+// A file's content is restored by replacing it atomically, so an interrupted
+// rollback never leaves it missing
+func restore(dir string, b *Backup) error {
+	switch {
+	case b.Created:
+		return removeBackedUp(b.Path)
+	case b.Link != "":
+		if err := removeBackedUp(b.Path); err != nil {
+			return err
+		}
+		if err := os.Symlink(b.Link, b.Path); err != nil {
+			return err
+		}
+		if b.Uid != -1 || b.Gid != -1 {
+			return os.Lchown(b.Path, b.Uid, b.Gid)
+		}
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, b.Copy))
+	if err != nil {
+		return err
+	}
+	return replaceFile(b.Path, content, b.Mode, b.Uid, b.Gid)
+}
+
+func removeBackedUp(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
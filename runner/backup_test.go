@@ -0,0 +1,109 @@
+package runner
+
+import (
+	"github.com/jdub/cfn-init-tools/config"
+	"github.com/jdub/cfn-init-tools/metadata"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRollback(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes and symlinks are not checked on Windows")
+	}
+
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing")
+	created := filepath.Join(dir, "created")
+	if err := ioutil.WriteFile(existing, []byte("pants"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(config.Config{DataDir: dir}, ioutil.Discard)
+	run := &ConfigRun{Name: "config", Result: Success}
+	err := r.files(run, map[string]*metadata.File{
+		existing: {Content: "trousers", Mode: "000644"},
+		created:  {Content: "shorts"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second change in the same run keeps the original backup
+	if _, err := r.writeFile(existing, &metadata.File{Content: "jeans"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if runs, err := Backups(dir); err != nil || !reflect.DeepEqual(runs, []string{r.Report.RunId}) {
+		t.Fatalf("%v %v", runs, err)
+	}
+
+	runId, restored, err := Rollback(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runId != r.Report.RunId || !reflect.DeepEqual(restored, []string{existing, created}) {
+		t.Errorf("%v %v", runId, restored)
+	}
+
+	if b, _ := ioutil.ReadFile(existing); string(b) != "pants" {
+		t.Errorf("%q != pants", b)
+	}
+	if info, _ := os.Stat(existing); info.Mode().Perm() != 0600 {
+		t.Errorf("%v != 0600", info.Mode().Perm())
+	}
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Error("created file should be removed")
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	if _, _, err := Rollback(dir, "pants"); err == nil {
+		t.Error("unknown run should be an error")
+	}
+
+	// A file whose backup can't be restored is left as it was
+	if err := restore(dir, &Backup{Path: existing, Mode: 0600, Uid: -1, Gid: -1, Copy: "pants"}); err == nil {
+		t.Error("missing backup copy should be an error")
+	}
+	if b, _ := ioutil.ReadFile(existing); string(b) != "pants" {
+		t.Errorf("%q != pants", b)
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pants")
+
+	var runs []*Runner
+	for _, content := range []string{"pants", "trousers"} {
+		r := New(config.Config{DataDir: dir}, ioutil.Discard)
+		if _, err := r.writeFile(path, &metadata.File{Content: content}, nil); err != nil {
+			t.Fatal(err)
+		}
+		runs = append(runs, r)
+	}
+	old, recent := runs[0].Report.RunId, runs[1].Report.RunId
+	week := time.Now().Add(-7 * 24 * time.Hour)
+	if err := os.Chtimes(filepath.Join(BackupDir(dir, old), "manifest.json"), week, week); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := PruneBackups(dir, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removed, []string{old}) {
+		t.Errorf("%v != [%v]", removed, old)
+	}
+	if _, err := os.Stat(BackupDir(dir, old)); !os.IsNotExist(err) {
+		t.Error("old backups should be removed")
+	}
+	if runs, err := Backups(dir); err != nil || !reflect.DeepEqual(runs, []string{recent}) {
+		t.Errorf("%v %v", runs, err)
+	}
+}
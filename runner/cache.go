@@ -0,0 +1,184 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/jdub/cfn-init-tools/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A downloaded file or source, kept so that unchanged content needn't be
+// downloaded again. The content is stored separately, named by its SHA-256.
+type Download struct {
+	Url          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	Sha256       string    `json:"sha256"`
+	Size         int64     `json:"size"`
+	Fetched      time.Time `json:"fetched"`
+	Used         time.Time `json:"used"`
+}
+
+// DownloadDir is where downloads are cached, under the data directory
+func DownloadDir(dataDir string) string {
+	return filepath.Join(dataDir, "downloads")
+}
+
+// Content is shared between URLs, so it's stored by hash
+func objectPath(dataDir string, sum string) string {
+	return filepath.Join(DownloadDir(dataDir), "objects", sum)
+}
+
+// Entries are keyed by URL
+func entryFile(dataDir string, url string) string {
+	key := sha256.Sum256([]byte(url))
+	return filepath.Join(DownloadDir(dataDir), "entries", hex.EncodeToString(key[:])+".json")
+}
+
+// Returns the cached download of url, if its content is still present
+func readDownload(dataDir string, url string) (*Download, error) {
+	b, err := ioutil.ReadFile(entryFile(dataDir, url))
+	if err != nil {
+		return nil, err
+	}
+
+	var d Download
+	if err := json.Unmarshal(b, &d); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(objectPath(dataDir, d.Sha256)); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func writeDownload(dataDir string, d *Download) error {
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	name := entryFile(dataDir, d.Url)
+	if err := os.MkdirAll(filepath.Dir(name), config.DataDirMode); err != nil {
+		return err
+	}
+
+	// Write atomically so an interrupted run can't leave a corrupt entry
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".entry-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := tmp.Write(b); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// Downloads returns every cached download, sorted by URL
+func Downloads(dataDir string) ([]*Download, error) {
+	names, err := filepath.Glob(filepath.Join(DownloadDir(dataDir), "entries", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var downloads []*Download
+	for _, name := range names {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		var d Download
+		if err := json.Unmarshal(b, &d); err != nil {
+			// A corrupt entry is useless, so it's left for pruning
+			continue
+		}
+		downloads = append(downloads, &d)
+	}
+
+	sort.Slice(downloads, func(i, j int) bool {
+		return downloads[i].Url < downloads[j].Url
+	})
+	return downloads, nil
+}
+
+// PruneDownloads removes cached downloads which haven't been used since
+// before, and any content no longer referenced, returning the URLs removed
+func PruneDownloads(dataDir string, before time.Time) ([]string, error) {
+	dir := DownloadDir(dataDir)
+
+	names, err := filepath.Glob(filepath.Join(dir, "entries", "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	keep := make(map[string]bool)
+	for _, name := range names {
+		var d Download
+		b, err := ioutil.ReadFile(name)
+		if err == nil {
+			err = json.Unmarshal(b, &d)
+		}
+		if err == nil && !d.Used.Before(before) {
+			keep[d.Sha256] = true
+			continue
+		}
+
+		if err := os.Remove(name); err != nil {
+			return removed, err
+		}
+		if d.Url != "" {
+			removed = append(removed, d.Url)
+		}
+	}
+
+	objects, err := filepath.Glob(filepath.Join(dir, "objects", "*"))
+	if err != nil {
+		return removed, err
+	}
+	for _, name := range objects {
+		base := filepath.Base(name)
+		// Skip downloads in progress
+		if keep[base] || strings.HasPrefix(base, ".") {
+			continue
+		}
+		if err := os.Remove(name); err != nil {
+			return removed, err
+		}
+	}
+
+	sort.Strings(removed)
+	return removed, nil
+}
@@ -0,0 +1,76 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDownloadCache(t *testing.T) {
+	content := "pants"
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		etag := fmt.Sprintf(`"%v"`, len(content))
+		if req.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		fmt.Fprint(w, content)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(objectPath(dir, ""), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []bool{true, false} {
+		path, changed, err := download(context.Background(), server.Client(), server.URL, "", dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed != want {
+			t.Errorf("download %v: changed %v != %v", i, changed, want)
+		}
+		if b, _ := ioutil.ReadFile(path); string(b) != content {
+			t.Errorf("download %v: %q != %q", i, b, content)
+		}
+	}
+
+	content = "trousers"
+	if path, changed, err := download(context.Background(), server.Client(), server.URL, "", dir); err != nil {
+		t.Fatal(err)
+	} else if b, _ := ioutil.ReadFile(path); !changed || string(b) != content {
+		t.Errorf("changed content should be downloaded again: %q", b)
+	}
+
+	downloads, err := Downloads(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(downloads) != 1 || downloads[0].Url != server.URL || downloads[0].Size != int64(len(content)) {
+		t.Errorf("unexpected downloads: %+v", downloads)
+	}
+
+	// The old content is no longer referenced, and is pruned
+	if removed, err := PruneDownloads(dir, time.Now().Add(-time.Hour)); err != nil || len(removed) != 0 {
+		t.Errorf("recently used downloads should be kept: %v %v", removed, err)
+	}
+	if objects, _ := ioutil.ReadDir(objectPath(dir, "")); len(objects) != 1 {
+		t.Errorf("%v objects != 1", len(objects))
+	}
+
+	if removed, err := PruneDownloads(dir, time.Now().Add(time.Hour)); err != nil || len(removed) != 1 {
+		t.Errorf("old downloads should be removed: %v %v", removed, err)
+	}
+	if objects, _ := ioutil.ReadDir(objectPath(dir, "")); len(objects) != 0 {
+		t.Errorf("%v objects != 0", len(objects))
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// A source URL may end with #sha256=<hex>, the same convention pip uses. The
+// fragment is never sent to the server, so AWS cfn-init ignores it.
+const checksumFragment = "#sha256="
+
+var validChecksum = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Splits the expected SHA-256 from a source URL
+func splitChecksum(url string) (string, string) {
+	if i := strings.LastIndex(url, checksumFragment); i >= 0 {
+		return url[:i], strings.ToLower(url[i+len(checksumFragment):])
+	}
+	return url, ""
+}
+
+func checkChecksum(sum string) error {
+	if sum != "" && !validChecksum.MatchString(sum) {
+		return fmt.Errorf("Invalid sha256 %q: expected 64 hexadecimal digits", sum)
+	}
+	return nil
+}
+
+// Fails with a clear message if content doesn't have the expected SHA-256
+func verify(what string, expected string, actual string) error {
+	if expected != "" && actual != expected {
+		return fmt.Errorf("sha256 mismatch for %v: expected %v, got %v", what, expected, actual)
+	}
+	return nil
+}
+
+func sum(content []byte) string {
+	h := sha256.Sum256(content)
+	return hex.EncodeToString(h[:])
+}
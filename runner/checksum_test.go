@@ -0,0 +1,86 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"github.com/jdub/cfn-init-tools/config"
+	"github.com/jdub/cfn-init-tools/metadata"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A valid sha256, but not of anything in these tests
+const otherSum = "f8c3c9cb3ac5c25e3ba5fde8b9ceb7b2a7d5e2b4b56a1f2c6b07bb04c0a7cbd4"
+
+func TestSplitChecksum(t *testing.T) {
+	url, expected := splitChecksum("http://example.com/a.tgz#sha256=" + otherSum)
+	if url != "http://example.com/a.tgz" || expected != otherSum {
+		t.Errorf("%v %v", url, expected)
+	}
+	if url, expected := splitChecksum("http://example.com/a.tgz"); url != "http://example.com/a.tgz" || expected != "" {
+		t.Errorf("%v %v", url, expected)
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	if _, err := remoteContent(&metadata.Config{Sources: map[string]string{"/opt": "http://example.com/#sha256=pants"}}); err == nil {
+		t.Error("invalid sha256 should be an error")
+	}
+}
+
+func TestVerifiedDownload(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		fmt.Fprint(w, "pants")
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(objectPath(dir, ""), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	actual := sum([]byte("pants"))
+	if _, _, err := download(context.Background(), server.Client(), server.URL+"/a", actual, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Content with the expected hash is already cached, even for another URL
+	if _, changed, err := download(context.Background(), server.Client(), server.URL+"/b", actual, dir); err != nil || changed {
+		t.Errorf("cached content should be used: %v %v", changed, err)
+	}
+	if requests != 1 {
+		t.Errorf("%v requests != 1", requests)
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	if _, _, err := download(context.Background(), server.Client(), server.URL+"/c", otherSum, dir); err == nil {
+		t.Error("sha256 mismatch should be an error")
+	}
+	if d, err := readDownload(dir, server.URL+"/c"); err == nil {
+		t.Errorf("mismatched download should not be cached: %+v", d)
+	}
+}
+
+func TestVerifiedFile(t *testing.T) {
+	dir := t.TempDir()
+	r := New(config.Config{DataDir: dir}, ioutil.Discard)
+	run := &ConfigRun{Name: "config", Result: Success}
+
+	path := filepath.Join(dir, "pants")
+	if err := r.files(run, map[string]*metadata.File{path: {Content: "pants", Sha256: sum([]byte("pants"))}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	if err := r.files(run, map[string]*metadata.File{path: {Content: "trousers", Sha256: sum([]byte("pants"))}}, nil); err == nil {
+		t.Error("sha256 mismatch should be an error")
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "pants" {
+		t.Errorf("mismatched content should not be written: %q", b)
+	}
+}
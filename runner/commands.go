@@ -0,0 +1,243 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"github.com/jdub/cfn-init-tools/metadata"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A command killed because it ran for too long
+type timeoutError struct {
+	after time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("timed out after %v", e.after)
+}
+
+// Commands are run in alphabetical order by name
+func (r *Runner) commands(ctx context.Context, run *ConfigRun, commands map[string]*metadata.Command) error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := r.command(ctx, run, name, commands[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Runner) command(ctx context.Context, run *ConfigRun, name string, c *metadata.Command) error {
+	s := startStep("command", name)
+	defer run.add(s)
+
+	// A command's own timeout overrides --command-timeout
+	timeout := r.Config.CommandTimeout
+	if c.Timeout > 0 {
+		timeout = time.Duration(c.Timeout)
+	}
+
+	if c.Test != "" {
+		code, out, err := r.exec(ctx, c.Test, c, timeout)
+		if t, ok := err.(*timeoutError); ok {
+			return r.timedOut(s, "Test for command "+name, t, out, bool(c.IgnoreErrors))
+		} else if err != nil {
+			s.finish(Failure, err.Error())
+			return fmt.Errorf("Command %v: test could not be run: %v", name, err)
+		}
+		if code != 0 {
+			r.log.Printf("Test for command %v failed with exit code %v, skipping:\n%s", name, code, out)
+			s.finish(Skipped, fmt.Sprintf("test exited with %v", code))
+			return nil
+		}
+	}
+
+	code, out, err := r.exec(ctx, c.Command, c, timeout)
+	if t, ok := err.(*timeoutError); ok {
+		s.Changed = true
+		return r.timedOut(s, "Command "+name, t, out, bool(c.IgnoreErrors))
+	} else if err != nil {
+		s.finish(Failure, err.Error())
+		return fmt.Errorf("Command %v could not be run: %v", name, err)
+	}
+
+	s.Changed = true
+	s.ExitCode = &code
+	r.log.Printf("Command %v exited with %v:\n%s", name, code, out)
+
+	if code != 0 {
+		if c.IgnoreErrors {
+			s.finish(Success, fmt.Sprintf("exited with %v, ignored", code))
+			return nil
+		}
+		s.finish(Failure, fmt.Sprintf("exited with %v", code))
+		return fmt.Errorf("Command %v failed with exit code %v", name, code)
+	}
+
+	s.finish(Success, "")
+	return nil
+}
+
+// A timeout is a failure, unless errors are ignored
+func (r *Runner) timedOut(s *Step, what string, t *timeoutError, out string, ignore bool) error {
+	r.log.Printf("%v %v, and was killed:\n%s", what, t, out)
+	if ignore {
+		s.finish(Success, t.Error()+", ignored")
+		return nil
+	}
+	s.finish(Failure, t.Error())
+	return fmt.Errorf("%v %v", what, t)
+}
+
+// Runs a command line in the shell, with the command's cwd and env. If it
+// runs for longer than timeout, or ctx is cancelled, it's killed along with
+// its process group.
+func (r *Runner) exec(ctx context.Context, line string, c *metadata.Command, timeout time.Duration) (int, string, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := shell(ctx, line)
+	cmd.Dir = c.Cwd
+
+	// Only a command still running when ctx is done is killed, along with
+	// any children in its process group
+	killed := false
+	cmd.Cancel = func() error {
+		killed = true
+		return killProcessGroup(cmd)
+	}
+
+	// Another user starts from a minimal login environment rather than ours,
+	// which env adds to
+	if c.User != "" || c.Group != "" {
+		login, err := runAs(cmd, c.User, c.Group)
+		if err != nil {
+			return -1, "", err
+		}
+		if c.User != "" {
+			cmd.Env = setEnv(nil, login)
+		}
+	}
+
+	// Otherwise, like AWS cfn-init, env replaces the environment rather than
+	// adding to it
+	if cmd.Env == nil && len(c.Env) == 0 {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = setEnv(cmd.Env, c.Env)
+
+	code, out, err := output(cmd)
+	if killed && ctx.Err() == context.DeadlineExceeded {
+		return -1, out, &timeoutError{timeout}
+	} else if killed {
+		return -1, out, ctx.Err()
+	}
+	return code, out, err
+}
+
+// Sets variables in an environment, replacing any existing values
+func setEnv(env []string, vars map[string]string) []string {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	result := make([]string, 0, len(env)+len(keys))
+	for _, kv := range env {
+		if _, ok := vars[strings.SplitN(kv, "=", 2)[0]]; !ok {
+			result = append(result, kv)
+		}
+	}
+	for _, k := range keys {
+		result = append(result, k+"="+vars[k])
+	}
+	return result
+}
+
+// Runs cmd, returning its exit code and combined output. An error means the
+// command could not be run at all.
+//
+// Output goes to a temporary file rather than a pipe, so a daemon started by
+// the command can keep writing to it after the command exits, without being
+// killed by SIGPIPE or holding up Wait.
+func output(cmd *exec.Cmd) (int, string, error) {
+	f, err := ioutil.TempFile("", "cfn-command-")
+	if err != nil {
+		return -1, "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	cmd.Stdout = f
+	cmd.Stderr = f
+
+	code := 0
+	err = cmd.Run()
+	if exit, ok := err.(*exec.ExitError); ok {
+		code, err = exit.ExitCode(), nil
+	} else if err != nil {
+		code = -1
+	}
+
+	// A daemon may still be writing, so only read what's there so far
+	out, rerr := ioutil.ReadAll(io.NewSectionReader(f, 0, fileSize(f)))
+	if err == nil {
+		err = rerr
+	}
+	return code, strings.TrimRight(string(out), "\n"), err
+}
+
+func fileSize(f *os.File) int64 {
+	if info, err := f.Stat(); err == nil {
+		return info.Size()
+	}
+	return 0
+}
+
+func shell(ctx context.Context, line string) *exec.Cmd {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", line)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", line)
+	}
+
+	// Children which outlive the shell are killed with it on timeout
+	setProcessGroup(cmd)
+	return cmd
+}
@@ -0,0 +1,171 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"github.com/jdub/cfn-init-tools/config"
+	"github.com/jdub/cfn-init-tools/metadata"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func run(t *testing.T, json string) (*Runner, error) {
+	m, err := metadata.Parse(json)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New(config.Config{}, ioutil.Discard)
+	return r, r.Run(context.Background(), m, []string{"default"})
+}
+
+func TestCommands(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands are run with sh")
+	}
+
+	r, err := run(t, `
+{
+    "AWS::CloudFormation::Init": {
+        "config": {
+            "commands": {
+                "2-fails": { "command": "exit 3", "ignoreErrors": "true" },
+                "1-test": { "command": "exit 1", "test": "test -n \"$NOPE\"" },
+                "3-env": { "command": "test \"$PANTS\" = yes", "env": { "PANTS": "yes" } }
+            }
+        }
+    }
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := r.Report.Configs[0].Steps
+	want := []struct {
+		kind, name, status string
+		code               int
+	}{
+		{"command", "1-test", Skipped, -1},
+		{"command", "2-fails", Success, 3},
+		{"command", "3-env", Success, 0},
+	}
+	if len(steps) != len(want) {
+		t.Fatalf("%v steps != %v", len(steps), len(want))
+	}
+	for i, w := range want {
+		s := steps[i]
+		if s.Type != w.kind || s.Name != w.name || s.Status != w.status {
+			t.Errorf("step %v: %v %v %v != %v %v %v", i, s.Type, s.Name, s.Status, w.kind, w.name, w.status)
+		}
+		if w.code >= 0 && (s.ExitCode == nil || *s.ExitCode != w.code) {
+			t.Errorf("step %v: exit code %v != %v", i, s.ExitCode, w.code)
+		}
+	}
+}
+
+func TestCommandFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands are run with sh")
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	r, err := run(t, `
+{
+    "AWS::CloudFormation::Init": {
+        "configSets": { "default": [ "first", "second" ] },
+        "first": { "commands": { "fail": { "command": "exit 2" } } },
+        "second": { "commands": { "never": { "command": "true" } } }
+    }
+}
+`)
+	if err == nil {
+		t.Fatal("failing command should be an error")
+	}
+
+	r.Report.Finish(err)
+	if r.Report.Result != Failure || len(r.Report.Configs) != 1 || r.Report.Configs[0].Result != Failure {
+		t.Errorf("report should record the failure and stop: %+v", r.Report)
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands are run with sh")
+	}
+
+	m, err := metadata.Parse(`
+{
+    "AWS::CloudFormation::Init": {
+        "config": {
+            "commands": {
+                "1-ignored": { "command": "sleep 30", "timeout": "0.2", "ignoreErrors": "true" },
+                "2-hung": { "command": "sleep 30 & sleep 30" }
+            }
+        }
+    }
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(config.Config{CommandTimeout: 200 * time.Millisecond}, ioutil.Discard)
+	started := time.Now()
+
+	// No, Mr. Bond, I expect you to die!
+	if err := r.Run(context.Background(), m, []string{"default"}); err == nil {
+		t.Fatal("timed out command should be an error")
+	}
+
+	// Neither the command nor its backgrounded child runs to completion
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("took %v to kill commands", elapsed)
+	}
+
+	steps := r.Report.Configs[0].Steps
+	if len(steps) != 2 || steps[0].Status != Success || steps[1].Status != Failure {
+		t.Errorf("unexpected steps: %+v %+v", steps[0], steps[1])
+	}
+}
+
+func TestBackgroundCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands are run with sh")
+	}
+
+	// A daemon keeps writing to the command's output after it exits
+	probe := filepath.Join(t.TempDir(), "alive")
+	started := time.Now()
+	r, err := run(t, fmt.Sprintf(`
+{
+    "AWS::CloudFormation::Init": {
+        "config": {
+            "commands": {
+                "daemon": { "command": "echo started; (sleep 1; echo still running; touch %v) &" }
+            }
+        }
+    }
+}
+`, probe))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(started); elapsed > 900*time.Millisecond {
+		t.Errorf("waited %v for the daemon", elapsed)
+	}
+	if s := r.Report.Configs[0].Steps[0]; s.Status != Success || *s.ExitCode != 0 {
+		t.Errorf("backgrounded command should succeed: %+v", s)
+	}
+
+	for i := 0; i < 50; i++ {
+		if _, err := os.Stat(probe); err == nil {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Error("daemon did not survive writing after the command exited")
+}
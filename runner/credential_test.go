@@ -0,0 +1,65 @@
+//go:build !windows
+// +build !windows
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"github.com/jdub/cfn-init-tools/config"
+	"github.com/jdub/cfn-init-tools/metadata"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"testing"
+)
+
+func TestRunAs(t *testing.T) {
+	// Only root can run commands as anyone else
+	u, err := user.Current()
+	if os.Geteuid() == 0 {
+		u, err = user.Lookup("nobody")
+	}
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// Another user doesn't see our environment, including our PATH
+	t.Setenv("CFN_TEST_SECRET", "pants")
+	t.Setenv("PATH", "/root/bin:"+os.Getenv("PATH"))
+	path := userPath
+	if u.Uid == "0" {
+		path = rootPath
+	}
+
+	commands := map[string]*metadata.Command{
+		"login": {
+			Command: fmt.Sprintf(`test "$(id -u)" = %v -a "$(id -g)" = %v -a "$HOME" = %q -a "$USER" = %q -a "$LOGNAME" = %q -a -n "$SHELL" -a "$PATH" = %q -a -z "$CFN_TEST_SECRET"`, u.Uid, u.Gid, u.HomeDir, u.Username, u.Username, path),
+			User:    u.Username,
+		},
+		"group": {
+			Command: fmt.Sprintf(`test "$(id -g)" = %v -a "$CFN_TEST_SECRET" = pants`, u.Gid),
+			Group:   u.Gid,
+		},
+		"env": {
+			Command: `test "$HOME" = /pants -a "$USER" = ` + u.Username,
+			User:    u.Uid,
+			Group:   u.Gid,
+			Env:     map[string]string{"HOME": "/pants"},
+		},
+	}
+
+	r := New(config.Config{}, ioutil.Discard)
+	run := &ConfigRun{Name: "config", Result: Success}
+	if err := r.commands(context.Background(), run, commands); err != nil {
+		t.Fatal(err)
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	err = r.commands(context.Background(), run, map[string]*metadata.Command{
+		"pants": {Command: "true", User: "no-such-user-pants"},
+	})
+	if err == nil {
+		t.Error("unknown user should be an error")
+	}
+}
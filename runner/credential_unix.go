@@ -0,0 +1,108 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build !windows
+// +build !windows
+
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// The PATH given to commands run as another user, as login(1) would
+const (
+	userPath = "/usr/local/bin:/usr/bin:/bin"
+	rootPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+)
+
+// Runs the command as a user and/or group, by name or ID, returning the login
+// environment for the user. The user's supplementary groups are set too, when
+// running as root.
+func runAs(cmd *exec.Cmd, owner string, group string) (map[string]string, error) {
+	uid, gid, err := ownerIds(owner, group)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := user.Current()
+	if uid != -1 {
+		u, err = user.LookupId(strconv.Itoa(uid))
+	}
+	if err != nil {
+		return nil, err
+	}
+	uid, _ = strconv.Atoi(u.Uid)
+	if gid == -1 {
+		gid, _ = strconv.Atoi(u.Gid)
+	}
+
+	cred := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), NoSetGroups: true}
+	if os.Geteuid() == 0 {
+		ids, err := u.GroupIds()
+		if err != nil {
+			return nil, err
+		}
+		cred.NoSetGroups = false
+		for _, id := range ids {
+			if n, err := strconv.Atoi(id); err == nil {
+				cred.Groups = append(cred.Groups, uint32(n))
+			}
+		}
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = cred
+
+	// Like su -, never our own PATH, which may include root-only directories
+	path := userPath
+	if uid == 0 {
+		path = rootPath
+	}
+	return map[string]string{
+		"PATH":    path,
+		"SHELL":   loginShell(u.Username),
+		"HOME":    u.HomeDir,
+		"USER":    u.Username,
+		"LOGNAME": u.Username,
+	}, nil
+}
+
+// Returns a user's shell from /etc/passwd, which os/user doesn't provide
+func loginShell(name string) string {
+	b, err := ioutil.ReadFile("/etc/passwd")
+	if err == nil {
+		for _, line := range strings.Split(string(b), "\n") {
+			fields := strings.Split(line, ":")
+			if len(fields) == 7 && fields[0] == name && fields[6] != "" {
+				return fields[6]
+			}
+		}
+	}
+	return "/bin/sh"
+}
@@ -0,0 +1,264 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package runner
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/jdub/cfn-init-tools/config"
+	"github.com/jdub/cfn-init-tools/metadata"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDownloadWorkers bounds how many files and sources are downloaded at
+// once
+const DefaultDownloadWorkers = 4
+
+// Returns every URL a config's files and sources are downloaded from, with
+// its expected SHA-256, if any
+func remoteContent(c *metadata.Config) (map[string]string, error) {
+	content := make(map[string]string)
+	add := func(url string, expected string) error {
+		if err := checkChecksum(expected); err != nil {
+			return fmt.Errorf("%v: %v", url, err)
+		}
+		if other, ok := content[url]; ok && other != "" && expected != "" && other != expected {
+			return fmt.Errorf("%v: conflicting sha256 %v and %v", url, other, expected)
+		}
+		if expected != "" || content[url] == "" {
+			content[url] = expected
+		}
+		return nil
+	}
+
+	for _, source := range c.Sources {
+		if err := add(splitChecksum(source)); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range c.Files {
+		if f.Source == "" {
+			continue
+		}
+		url, expected := splitChecksum(f.Source)
+		if f.Sha256 != "" {
+			expected = strings.ToLower(f.Sha256)
+		}
+		if err := add(url, expected); err != nil {
+			return nil, err
+		}
+	}
+	return content, nil
+}
+
+// Downloads all of a config's files and sources concurrently, before any of
+// them are written, returning the cached path for each URL. Content with an
+// expected SHA-256 is verified. The first failure cancels the remaining
+// downloads.
+func (r *Runner) prefetch(ctx context.Context, run *ConfigRun, c *metadata.Config) (map[string]string, error) {
+	content, err := remoteContent(c)
+	if err != nil || len(content) == 0 {
+		return nil, err
+	}
+
+	urls := make([]string, 0, len(content))
+	for url := range content {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+
+	client, err := metadata.HTTPClient(r.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Join(DownloadDir(r.Config.DataDir), "objects"), config.DataDirMode); err != nil {
+		return nil, err
+	}
+
+	workers := r.Config.DownloadWorkers
+	if workers < 1 {
+		workers = DefaultDownloadWorkers
+	}
+	sem := make(chan struct{}, workers)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	staged := make(map[string]string)
+	steps := make(map[string]*Step)
+	var failed error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, url := range urls {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+
+			s := startStep("download", url)
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				s.finish(Skipped, ctx.Err().Error())
+				mu.Lock()
+				steps[url] = s
+				mu.Unlock()
+				return
+			}
+
+			r.log.Printf("Downloading %v", url)
+			path, changed, err := download(ctx, client, url, content[url], r.Config.DataDir)
+
+			mu.Lock()
+			defer mu.Unlock()
+			steps[url] = s
+			if err != nil {
+				s.finish(Failure, err.Error())
+				if failed == nil {
+					failed = fmt.Errorf("Could not download %v: %v", url, err)
+					cancel()
+				}
+				return
+			}
+			s.Changed = changed
+			if changed {
+				s.finish(Success, "")
+			} else {
+				s.finish(Success, "unchanged, using cached copy")
+			}
+			staged[url] = path
+		}(url)
+	}
+	wg.Wait()
+
+	for _, url := range urls {
+		run.add(steps[url])
+	}
+	if failed != nil {
+		return nil, failed
+	}
+	return staged, nil
+}
+
+// Downloads url into the cache, unless the cached copy is still current
+// according to its ETag or Last-Modified date, or already has the expected
+// SHA-256
+func download(ctx context.Context, client *http.Client, url string, expected string, dataDir string) (path string, changed bool, err error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", false, err
+	}
+
+	now := time.Now().UTC()
+
+	// Content is shared between URLs, so any copy with the right hash will do
+	cached, _ := readDownload(dataDir, url)
+	if expected != "" {
+		if cached == nil || cached.Sha256 != expected {
+			if info, err := os.Stat(objectPath(dataDir, expected)); err == nil {
+				cached = &Download{Url: url, Sha256: expected, Size: info.Size(), Fetched: now}
+			}
+		}
+		if cached != nil && cached.Sha256 == expected {
+			cached.Used = now
+			return objectPath(dataDir, expected), false, writeDownload(dataDir, cached)
+		}
+	}
+
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", false, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified && cached != nil {
+		if err := verify(url, expected, cached.Sha256); err != nil {
+			return "", false, err
+		}
+		cached.Used = now
+		return objectPath(dataDir, cached.Sha256), false, writeDownload(dataDir, cached)
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", false, fmt.Errorf("%v", res.Status)
+	}
+
+	f, err := ioutil.TempFile(filepath.Join(DownloadDir(dataDir), "objects"), ".download-")
+	if err != nil {
+		return "", false, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), res.Body)
+	if err != nil {
+		return "", false, err
+	}
+	if err := f.Close(); err != nil {
+		return "", false, err
+	}
+
+	d := &Download{
+		Url:          url,
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+		Sha256:       hex.EncodeToString(h.Sum(nil)),
+		Size:         size,
+		Fetched:      now,
+		Used:         now,
+	}
+
+	// Content that fails its check is discarded, rather than cached
+	if err := verify(url, expected, d.Sha256); err != nil {
+		return "", false, err
+	}
+
+	path = objectPath(dataDir, d.Sha256)
+	if err := os.Rename(f.Name(), path); err != nil {
+		return "", false, err
+	}
+	if err := writeDownload(dataDir, d); err != nil {
+		return "", false, err
+	}
+
+	return path, cached == nil || cached.Sha256 != d.Sha256, nil
+}
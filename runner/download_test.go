@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"github.com/jdub/cfn-init-tools/config"
+	"github.com/jdub/cfn-init-tools/metadata"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrefetch(t *testing.T) {
+	// The first two requests wait for each other, so they only succeed
+	// concurrently
+	var mu sync.Mutex
+	arrived := 0
+	both := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/missing":
+			http.NotFound(w, req)
+			return
+		case "/stalled":
+			fmt.Fprint(w, "half")
+			w.(http.Flusher).Flush()
+			<-req.Context().Done()
+			return
+		case "/slow":
+			for i := 0; i < 10; i++ {
+				fmt.Fprint(w, "slow")
+				w.(http.Flusher).Flush()
+				time.Sleep(30 * time.Millisecond)
+			}
+			return
+		}
+		mu.Lock()
+		if arrived++; arrived == 2 {
+			close(both)
+		}
+		mu.Unlock()
+		select {
+		case <-both:
+			fmt.Fprint(w, req.URL.Path)
+		case <-time.After(2 * time.Second):
+			http.Error(w, "alone", http.StatusGatewayTimeout)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	r := New(config.Config{DataDir: dir, DownloadWorkers: 2}, ioutil.Discard)
+	run := &ConfigRun{Name: "config", Result: Success}
+
+	c := &metadata.Config{
+		Sources: map[string]string{"/opt/a": server.URL + "/a"},
+		Files:   map[string]*metadata.File{"/etc/b": {Source: server.URL + "/b"}},
+	}
+	staged, err := r.prefetch(context.Background(), run, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a", "b"} {
+		b, err := ioutil.ReadFile(staged[server.URL+"/"+name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "/"+name {
+			t.Errorf("%v: %q != %q", name, b, "/"+name)
+		}
+	}
+	if len(run.Steps) != 2 || run.Steps[0].Name != server.URL+"/a" {
+		t.Errorf("unexpected steps: %+v", run.Steps)
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	c.Files["/etc/missing"] = &metadata.File{Source: server.URL + "/missing"}
+	if _, err := r.prefetch(context.Background(), run, c); err == nil {
+		t.Error("missing file should be an error")
+	}
+
+	// A download that takes longer than the timeout, but keeps making
+	// progress, isn't cut off
+	r = New(config.Config{DataDir: dir, HttpTimeout: 100 * time.Millisecond}, ioutil.Discard)
+	slow := &metadata.Config{Files: map[string]*metadata.File{"/etc/slow": {Source: server.URL + "/slow"}}}
+	if staged, err := r.prefetch(context.Background(), run, slow); err != nil {
+		t.Error(err)
+	} else if b, _ := ioutil.ReadFile(staged[server.URL+"/slow"]); len(b) != 40 {
+		t.Errorf("%v bytes != 40", len(b))
+	}
+
+	// A download that stops part way through times out, and isn't cached
+	stalled := &metadata.Config{Files: map[string]*metadata.File{"/etc/stalled": {Source: server.URL + "/stalled"}}}
+	if _, err := r.prefetch(context.Background(), run, stalled); err == nil {
+		t.Error("stalled download should time out")
+	}
+	if d, err := readDownload(dir, server.URL+"/stalled"); err == nil {
+		t.Errorf("stalled download was cached: %+v", d)
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package runner
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/jdub/cfn-init-tools/metadata"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The parts of a file's mode set by the mode in metadata
+const modeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// How a file was changed
+const (
+	Created   = "created"
+	Updated   = "updated"
+	Unchanged = "unchanged"
+)
+
+// Files are written in alphabetical order by path
+func (r *Runner) files(run *ConfigRun, files map[string]*metadata.File, staged map[string]string) error {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		s := startStep("file", path)
+		change, err := r.writeFile(path, files[path], staged)
+		if err != nil {
+			s.finish(Failure, err.Error())
+			run.add(s)
+			return fmt.Errorf("Could not write %v: %v", path, err)
+		}
+		s.Changed = change != Unchanged
+		s.finish(Success, change)
+		run.add(s)
+	}
+	return nil
+}
+
+// Writes a file, changing only the content, mode or ownership that differ
+// from what's on disk, and describing the change
+func (r *Runner) writeFile(path string, f *metadata.File, staged map[string]string) (string, error) {
+	if len(f.Context) > 0 {
+		return "", fmt.Errorf("Mustache templates (context) are not supported")
+	}
+
+	mode, symlink, err := fileMode(f.Mode)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := fileContent(f, staged)
+	if err != nil {
+		return "", err
+	}
+	if err := checkChecksum(strings.ToLower(f.Sha256)); err != nil {
+		return "", err
+	}
+	if err := verify(path, strings.ToLower(f.Sha256), sum(content)); err != nil {
+		return "", err
+	}
+
+	uid, gid, err := ownerIds(f.Owner, f.Group)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	exists := err == nil
+
+	// Metadata without a mode, owner or group leaves an existing file's alone
+	if exists && info.Mode().IsRegular() {
+		if f.Mode == "" {
+			mode = info.Mode() & modeBits
+		}
+		ownerUid, ownerGid := fileOwner(info)
+		if uid == -1 {
+			uid = ownerUid
+		}
+		if gid == -1 {
+			gid = ownerGid
+		}
+	}
+
+	if symlink {
+		if exists && info.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Readlink(path); err == nil && target == string(content) {
+				return Unchanged, nil
+			}
+		}
+		r.log.Printf("Linking %v to %v", path, string(content))
+		if err := r.backup(path); err != nil {
+			return "", err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return "", err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		if err := os.Symlink(string(content), path); err != nil {
+			return "", err
+		}
+		return changed(exists, "target"), nil
+	}
+
+	if !exists || !info.Mode().IsRegular() || !sameContent(path, content) {
+		r.log.Printf("Writing %v", path)
+		if err := r.backup(path); err != nil {
+			return "", err
+		}
+		if err := replaceFile(path, content, mode, uid, gid); err != nil {
+			return "", err
+		}
+		return changed(exists, "content"), nil
+	}
+
+	// The content is right, but the mode or ownership may not be
+	var what []string
+	if info.Mode()&modeBits != mode {
+		r.log.Printf("Changing mode of %v to %v", path, mode)
+		if err := r.backup(path); err != nil {
+			return "", err
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			return "", err
+		}
+		what = append(what, "mode")
+	}
+	if !ownedBy(info, uid, gid) {
+		r.log.Printf("Changing ownership of %v to %v:%v", path, f.Owner, f.Group)
+		if err := r.backup(path); err != nil {
+			return "", err
+		}
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return "", err
+		}
+		what = append(what, "owner")
+	}
+
+	if len(what) == 0 {
+		return Unchanged, nil
+	}
+	return Updated + " (" + strings.Join(what, ", ") + ")", nil
+}
+
+func changed(existed bool, what string) string {
+	if existed {
+		return Updated + " (" + what + ")"
+	}
+	return Created
+}
+
+func sameContent(path string, content []byte) bool {
+	b, err := ioutil.ReadFile(path)
+	return err == nil && bytes.Equal(b, content)
+}
+
+// Writes to a temporary file, then renames it, so the file is replaced all at
+// once with the right content, mode and ownership
+func replaceFile(path string, content []byte, mode os.FileMode, uid int, gid int) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := tmp.Write(content); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		return err
+	}
+	// Only root can give a file away, so skip ownership that's already right
+	if info, err := tmp.Stat(); err != nil {
+		return err
+	} else if !ownedBy(info, uid, gid) {
+		if err := tmp.Chown(uid, gid); err != nil {
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Returns a file's content, either from metadata or the staged download
+func fileContent(f *metadata.File, staged map[string]string) ([]byte, error) {
+	if f.Source != "" {
+		url, _ := splitChecksum(f.Source)
+		path, ok := staged[url]
+		if !ok {
+			return nil, fmt.Errorf("%v was not downloaded", f.Source)
+		}
+		return ioutil.ReadFile(path)
+	}
+
+	switch f.Encoding {
+	case "", "plain":
+		return []byte(f.Content), nil
+	case "base64":
+		return base64.StdEncoding.DecodeString(f.Content)
+	}
+	return nil, fmt.Errorf("Unknown encoding %q", f.Encoding)
+}
+
+// Parses a six digit octal mode, e.g. 000644, or 120000 for a symlink whose
+// content is its target. The default, for new files, is 000644.
+func fileMode(mode string) (m os.FileMode, symlink bool, err error) {
+	if mode == "" {
+		return 0644, false, nil
+	}
+	bits, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || bits > 0777777 {
+		return 0, false, fmt.Errorf("Invalid mode %q", mode)
+	}
+
+	m = os.FileMode(bits & 0777)
+	if bits&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if bits&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if bits&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m, bits&0170000 == 0120000, nil
+}
+
+// Looks up an owner and group by name or ID, returning -1 for those which are
+// empty, and are left unchanged. Ownership is not changed on Windows.
+func ownerIds(owner string, group string) (uid int, gid int, err error) {
+	uid, gid = -1, -1
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	if owner != "" {
+		u, err := user.Lookup(owner)
+		if err != nil {
+			if u, err = user.LookupId(owner); err != nil {
+				return -1, -1, fmt.Errorf("Unknown owner %v", owner)
+			}
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return -1, -1, fmt.Errorf("Unknown group %v", group)
+			}
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	return
+}
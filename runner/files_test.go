@@ -0,0 +1,147 @@
+package runner
+
+import (
+	"github.com/jdub/cfn-init-tools/config"
+	"github.com/jdub/cfn-init-tools/metadata"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestFileMode(t *testing.T) {
+	tests := map[string]os.FileMode{
+		"":       0644,
+		"000600": 0600,
+		"100755": 0755,
+		"004755": os.ModeSetuid | 0755,
+		"001777": os.ModeSticky | 0777,
+	}
+	for mode, want := range tests {
+		if m, symlink, err := fileMode(mode); err != nil || symlink || m != want {
+			t.Errorf("%q: %v %v %v != %v", mode, m, symlink, err, want)
+		}
+	}
+
+	if _, symlink, err := fileMode("120000"); err != nil || !symlink {
+		t.Errorf("120000 should be a symlink")
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	for _, mode := range []string{"pants", "999", "10000000"} {
+		if _, _, err := fileMode(mode); err == nil {
+			t.Errorf("%q should be an error", mode)
+		}
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	staged := filepath.Join(dir, "staged")
+	if err := ioutil.WriteFile(staged, []byte("downloaded"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(config.Config{DataDir: dir}, ioutil.Discard)
+	run := &ConfigRun{Name: "config", Result: Success}
+	err := r.files(run, map[string]*metadata.File{
+		filepath.Join(dir, "a/plain"):  {Content: "pants", Mode: "000600"},
+		filepath.Join(dir, "b/base64"): {Content: "cGFudHM=", Encoding: "base64"},
+		filepath.Join(dir, "c/source"): {Source: "http://example.com/source"},
+	}, map[string]string{"http://example.com/source": staged})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{"a/plain": "pants", "b/base64": "pants", "c/source": "downloaded"}
+	for name, want := range tests {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("%v: %q != %q", name, b, want)
+		}
+	}
+
+	if info, err := os.Stat(filepath.Join(dir, "a/plain")); err != nil {
+		t.Fatal(err)
+	} else if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("%v != 0600", info.Mode().Perm())
+	}
+
+	if len(run.Steps) != 3 || run.Steps[0].Type != "file" || !run.Steps[0].Changed {
+		t.Errorf("unexpected steps: %+v", run.Steps)
+	}
+}
+
+func TestFilesIdempotent(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes and symlinks are not checked on Windows")
+	}
+
+	dir := t.TempDir()
+	r := New(config.Config{DataDir: dir}, ioutil.Discard)
+	path := filepath.Join(dir, "pants")
+	link := filepath.Join(dir, "link")
+
+	tests := []struct {
+		file *metadata.File
+		want string
+	}{
+		{&metadata.File{Content: "pants", Mode: "000600"}, Created},
+		{&metadata.File{Content: "pants", Mode: "000600"}, Unchanged},
+		{&metadata.File{Content: "pants", Mode: "000640"}, Updated + " (mode)"},
+		{&metadata.File{Content: "trousers", Mode: "000640"}, Updated + " (content)"},
+	}
+	for i, test := range tests {
+		if change, err := r.writeFile(path, test.file, nil); err != nil {
+			t.Fatal(err)
+		} else if change != test.want {
+			t.Errorf("write %v: %q != %q", i, change, test.want)
+		}
+	}
+
+	before, _ := os.Stat(path)
+	r.writeFile(path, tests[3].file, nil)
+	if after, _ := os.Stat(path); !after.ModTime().Equal(before.ModTime()) {
+		t.Error("unchanged file should not be rewritten")
+	}
+
+	for i, want := range []string{Created, Unchanged} {
+		if change, err := r.writeFile(link, &metadata.File{Content: path, Mode: "120000"}, nil); err != nil {
+			t.Fatal(err)
+		} else if change != want {
+			t.Errorf("link %v: %q != %q", i, change, want)
+		}
+	}
+
+	// Without mode, owner or group, an existing file keeps its own, even
+	// when its content changes
+	existing := filepath.Join(dir, "existing")
+	if err := ioutil.WriteFile(existing, []byte("pants"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	uid, gid := os.Getuid(), os.Getgid()
+	if uid == 0 {
+		uid, gid = 65534, 65534
+		if err := os.Chown(existing, uid, gid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, test := range []struct{ content, want string }{
+		{"pants", Unchanged},
+		{"trousers", Updated + " (content)"},
+	} {
+		if change, err := r.writeFile(existing, &metadata.File{Content: test.content}, nil); err != nil {
+			t.Fatal(err)
+		} else if change != test.want {
+			t.Errorf("existing %v: %q != %q", i, change, test.want)
+		}
+		info, _ := os.Stat(existing)
+		if info.Mode().Perm() != 0600 || !ownedBy(info, uid, gid) {
+			t.Errorf("existing %v: mode %v or ownership changed", i, info.Mode().Perm())
+		}
+	}
+}
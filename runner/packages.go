@@ -0,0 +1,297 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package runner
+
+import (
+	"fmt"
+	"github.com/jdub/cfn-init-tools/metadata"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultPackageWorkers bounds how many package managers run at once with
+// --parallel-packages
+const DefaultPackageWorkers = 4
+
+// A package manager knows how to check for and install a package version.
+// An empty version means the latest.
+type manager struct {
+	name string
+	// Managers sharing a database can't run at the same time
+	lock      string
+	installed func(name string, version string) []string
+	install   func(name string, version string) []string
+}
+
+var managers = map[string]*manager{
+	"msi": {
+		name: "msi",
+		lock: "msi",
+		install: func(name, location string) []string {
+			return []string{"msiexec", "/i", location, "/qn", "/norestart"}
+		},
+	},
+	"rpm": {
+		name: "rpm",
+		lock: "rpm",
+		installed: func(name, location string) []string {
+			return []string{"rpm", "-q", name}
+		},
+		install: func(name, location string) []string {
+			return []string{"rpm", "-U", "--quiet", location}
+		},
+	},
+	"yum": {
+		name: "yum",
+		lock: "rpm",
+		installed: func(name, version string) []string {
+			return []string{"rpm", "-q", join(name, "-", version)}
+		},
+		install: func(name, version string) []string {
+			return []string{"yum", "-y", "install", join(name, "-", version)}
+		},
+	},
+	"apt": {
+		name: "apt",
+		lock: "dpkg",
+		installed: func(name, version string) []string {
+			return []string{"dpkg-query", "-W", "-f", "${Status}\n${Version}\n", name}
+		},
+		install: func(name, version string) []string {
+			return []string{"apt-get", "-q", "-y", "install", join(name, "=", version)}
+		},
+	},
+	"python": {
+		name: "python",
+		lock: "python",
+		installed: func(name, version string) []string {
+			return []string{"pip", "show", name}
+		},
+		install: func(name, version string) []string {
+			return []string{"pip", "install", "-q", join(name, "==", version)}
+		},
+	},
+	"rubygems": {
+		name: "rubygems",
+		lock: "rubygems",
+		installed: func(name, version string) []string {
+			if version != "" {
+				return []string{"gem", "list", "-i", name, "-v", version}
+			}
+			return []string{"gem", "list", "-i", name}
+		},
+		install: func(name, version string) []string {
+			if version != "" {
+				return []string{"gem", "install", "--no-document", name, "-v", version}
+			}
+			return []string{"gem", "install", "--no-document", name}
+		},
+	},
+}
+
+func join(name string, sep string, version string) string {
+	if version == "" {
+		return name
+	}
+	return name + sep + version
+}
+
+// A package version to install
+type pkg struct {
+	manager *manager
+	name    string
+	version string
+}
+
+// Returns the packages to install in the same order as AWS cfn-init: rpm,
+// then yum and apt, then rubygems and python (or just msi, on Windows).
+// Within a manager, packages are installed in alphabetical order.
+func packageOrder(p *metadata.Package) []pkg {
+	var list []pkg
+
+	locations := func(m *manager, packages map[string]string) {
+		for _, name := range sortedKeys(packages) {
+			list = append(list, pkg{m, name, packages[name]})
+		}
+	}
+	versions := func(m *manager, packages map[string][]string) {
+		names := make([]string, 0, len(packages))
+		for name := range packages {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if len(packages[name]) == 0 {
+				list = append(list, pkg{m, name, ""})
+			}
+			for _, version := range packages[name] {
+				list = append(list, pkg{m, name, version})
+			}
+		}
+	}
+
+	if runtime.GOOS == "windows" {
+		locations(managers["msi"], p.Msi)
+		return list
+	}
+
+	locations(managers["rpm"], p.Rpm)
+	versions(managers["yum"], p.Yum)
+	versions(managers["apt"], p.Apt)
+	versions(managers["rubygems"], p.RubyGems)
+	versions(managers["python"], p.Python)
+	return list
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Installs packages in order, or with --parallel-packages, runs managers that
+// don't share a database concurrently. Steps are reported in order either way.
+func (r *Runner) packages(run *ConfigRun, p *metadata.Package) error {
+	order := packageOrder(p)
+
+	if !r.Config.ParallelPackages {
+		for _, k := range order {
+			s, err := r.installPackage(k)
+			run.add(s)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Each lock group installs its packages in order, on its own worker
+	var locks []string
+	groups := make(map[string][]pkg)
+	for _, k := range order {
+		if _, ok := groups[k.manager.lock]; !ok {
+			locks = append(locks, k.manager.lock)
+		}
+		groups[k.manager.lock] = append(groups[k.manager.lock], k)
+	}
+
+	workers := r.Config.PackageWorkers
+	if workers < 1 {
+		workers = DefaultPackageWorkers
+	}
+	sem := make(chan struct{}, workers)
+
+	steps := make(map[string][]*Step)
+	errs := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, lock := range locks {
+		wg.Add(1)
+		go func(lock string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			for _, k := range groups[lock] {
+				s, err := r.installPackage(k)
+				mu.Lock()
+				steps[lock] = append(steps[lock], s)
+				errs[lock] = err
+				mu.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}(lock)
+	}
+	wg.Wait()
+
+	var failed error
+	for _, lock := range locks {
+		for _, s := range steps[lock] {
+			run.add(s)
+		}
+		if errs[lock] != nil && failed == nil {
+			failed = errs[lock]
+		}
+	}
+	return failed
+}
+
+func (r *Runner) installPackage(k pkg) (*Step, error) {
+	label := join(k.name, " ", k.version)
+	s := startStep("package", k.manager.name+":"+label)
+
+	if k.manager.installed != nil {
+		argv := k.manager.installed(k.name, k.version)
+		if code, out, err := output(exec.Command(argv[0], argv[1:]...)); err == nil && code == 0 && isInstalled(k, out) {
+			s.finish(Success, "already installed")
+			return s, nil
+		}
+	}
+
+	argv := k.manager.install(k.name, k.version)
+	r.log.Printf("Installing %v package %v: %v", k.manager.name, label, strings.Join(argv, " "))
+
+	code, out, err := output(exec.Command(argv[0], argv[1:]...))
+	if err != nil {
+		s.finish(Failure, err.Error())
+		return s, fmt.Errorf("Could not install %v package %v: %v", k.manager.name, label, err)
+	}
+	s.Changed = true
+	s.ExitCode = &code
+	if code != 0 {
+		r.log.Printf("Installing %v package %v failed with exit code %v:\n%s", k.manager.name, label, code, out)
+		s.finish(Failure, fmt.Sprintf("exited with %v", code))
+		return s, fmt.Errorf("Could not install %v package %v: exited with %v", k.manager.name, label, code)
+	}
+
+	s.finish(Success, "")
+	return s, nil
+}
+
+// dpkg-query also knows about removed packages, and neither it nor pip can
+// query a specific version
+func isInstalled(k pkg, out string) bool {
+	lines := strings.Split(out, "\n")
+	switch k.manager.name {
+	case "apt":
+		return lines[0] == "install ok installed" && (k.version == "" || len(lines) > 1 && lines[1] == k.version)
+	case "python":
+		if k.version == "" {
+			return true
+		}
+		for _, line := range lines {
+			if strings.TrimSpace(line) == "Version: "+k.version {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package runner
+
+import (
+	"github.com/jdub/cfn-init-tools/config"
+	"github.com/jdub/cfn-init-tools/metadata"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// Installs fake package managers in PATH which log their arguments. pip and
+// gem each wait for the other to start, so they only succeed in parallel.
+func fakeManagers(t *testing.T) string {
+	dir := t.TempDir()
+	scripts := map[string]string{
+		"rpm":        `[ "$1" = -q ] && [ "$2" = installed ] && exit 0; [ "$1" = -q ] && exit 1; echo "rpm $*" >> "$LOG"`,
+		"yum":        `echo "yum $*" >> "$LOG"; [ "$3" != broken ]`,
+		"dpkg-query": `exit 1`,
+		"apt-get":    `echo "apt-get $*" >> "$LOG"`,
+		"pip":        `[ "$1" = show ] && exit 1; touch "$DIR/pip"; for i in 1 2 3 4 5 6 7 8 9 10; do [ -e "$DIR/gem" ] && exit 0; sleep 0.2; done; exit 1`,
+		"gem":        `[ "$1" = list ] && exit 1; touch "$DIR/gem"; for i in 1 2 3 4 5 6 7 8 9 10; do [ -e "$DIR/pip" ] && exit 0; sleep 0.2; done; exit 1`,
+	}
+	for name, script := range scripts {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("LOG", filepath.Join(dir, "log"))
+	t.Setenv("DIR", dir)
+	return dir
+}
+
+func TestPackageOrder(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("only msi packages are installed on Windows")
+	}
+
+	order := packageOrder(&metadata.Package{
+		Python:   map[string][]string{"boto": {}},
+		Yum:      map[string][]string{"httpd": {}, "git": {"1.2", "1.3"}},
+		Rpm:      map[string]string{"epel": "http://example.com/epel.rpm"},
+		RubyGems: map[string][]string{"chef": {"0.10.2"}},
+	})
+	var got []string
+	for _, k := range order {
+		got = append(got, k.manager.name+":"+join(k.name, " ", k.version))
+	}
+	want := []string{"rpm:epel http://example.com/epel.rpm", "yum:git 1.2", "yum:git 1.3", "yum:httpd", "rubygems:chef 0.10.2", "python:boto"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%v != %v", got, want)
+	}
+}
+
+func TestPackages(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake package managers are shell scripts")
+	}
+	dir := fakeManagers(t)
+
+	r := New(config.Config{}, ioutil.Discard)
+	run := &ConfigRun{Name: "config", Result: Success}
+	err := r.packages(run, &metadata.Package{
+		Rpm: map[string]string{"installed": "/tmp/installed.rpm", "epel": "/tmp/epel.rpm"},
+		Yum: map[string][]string{"httpd": {"2.4"}},
+		Apt: map[string][]string{"nginx": {}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "rpm -U --quiet /tmp/epel.rpm\nyum -y install httpd-2.4\napt-get -q -y install nginx\n"
+	if string(b) != want {
+		t.Errorf("%q != %q", b, want)
+	}
+
+	if len(run.Steps) != 4 || run.Steps[1].Name != "rpm:installed /tmp/installed.rpm" || run.Steps[1].Changed {
+		t.Errorf("installed package should be unchanged: %+v", run.Steps[1])
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	err = r.packages(run, &metadata.Package{Yum: map[string][]string{"broken": {}}})
+	if err == nil || run.Result != Failure {
+		t.Error("broken package should fail")
+	}
+}
+
+func TestParallelPackages(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake package managers are shell scripts")
+	}
+	fakeManagers(t)
+
+	r := New(config.Config{ParallelPackages: true, PackageWorkers: 2}, ioutil.Discard)
+	run := &ConfigRun{Name: "config", Result: Success}
+	err := r.packages(run, &metadata.Package{
+		Python:   map[string][]string{"boto": {}},
+		RubyGems: map[string][]string{"chef": {}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Steps are still reported in the usual order
+	if len(run.Steps) != 2 || run.Steps[0].Name != "rubygems:chef" || run.Steps[1].Name != "python:boto" {
+		t.Errorf("unexpected steps: %+v %+v", run.Steps[0], run.Steps[1])
+	}
+}
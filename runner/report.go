@@ -0,0 +1,170 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package runner
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/jdub/cfn-init-tools/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Step and config statuses
+const (
+	Success = "success"
+	Failure = "failure"
+	Skipped = "skipped"
+)
+
+// Report describes a single run, for fleet tooling to aggregate outcomes
+type Report struct {
+	RunId    string       `json:"runId"`
+	Started  time.Time    `json:"started"`
+	Finished time.Time    `json:"finished"`
+	Duration float64      `json:"duration"`
+	Result   string       `json:"result"`
+	Error    string       `json:"error,omitempty"`
+	Configs  []*ConfigRun `json:"configs"`
+}
+
+// ConfigRun is the outcome of a single config in a configSet
+type ConfigRun struct {
+	Name   string  `json:"name"`
+	Result string  `json:"result"`
+	Steps  []*Step `json:"steps"`
+}
+
+// Step is the outcome of a single item in a config section, such as a
+// command or file
+type Step struct {
+	Type     string    `json:"type"`
+	Name     string    `json:"name"`
+	Status   string    `json:"status"`
+	Changed  bool      `json:"changed"`
+	ExitCode *int      `json:"exitCode,omitempty"`
+	Started  time.Time `json:"started"`
+	Duration float64   `json:"duration"`
+	Message  string    `json:"message,omitempty"`
+}
+
+// NewReport starts a report with a new, sortable run ID
+func NewReport() *Report {
+	now := time.Now().UTC()
+	b := make([]byte, 3)
+	rand.Read(b)
+	return &Report{
+		RunId:   now.Format("20060102T150405Z") + "-" + hex.EncodeToString(b),
+		Started: now,
+		Result:  Success,
+		Configs: []*ConfigRun{},
+	}
+}
+
+// Finish records the end of the run and its overall result
+func (r *Report) Finish(err error) {
+	r.Finished = time.Now().UTC()
+	r.Duration = r.Finished.Sub(r.Started).Seconds()
+	if err != nil {
+		r.Result = Failure
+		r.Error = err.Error()
+	}
+}
+
+// Json returns the report as indented JSON
+func (r *Report) Json() (string, error) {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Path returns where the report is written in the data directory
+func (r *Report) Path(dataDir string) string {
+	return filepath.Join(dataDir, "reports", r.RunId+".json")
+}
+
+// Write saves the report under the data directory, readable only by its owner
+func (r *Report) Write(dataDir string) error {
+	j, err := r.Json()
+	if err != nil {
+		return err
+	}
+
+	name := r.Path(dataDir)
+	if err := os.MkdirAll(filepath.Dir(name), config.DataDirMode); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(name, []byte(j+"\n"), 0600); err != nil {
+		return fmt.Errorf("Could not write report: %v", err)
+	}
+	return nil
+}
+
+// PruneReports removes reports of runs written before before, returning the
+// run IDs removed
+func PruneReports(dataDir string, before time.Time) ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(dataDir, "reports", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	var removed []string
+	for _, name := range names {
+		info, err := os.Stat(name)
+		if err != nil {
+			return removed, err
+		}
+		if !info.ModTime().Before(before) {
+			continue
+		}
+		if err := os.Remove(name); err != nil {
+			return removed, err
+		}
+		removed = append(removed, strings.TrimSuffix(filepath.Base(name), ".json"))
+	}
+	return removed, nil
+}
+
+func (c *ConfigRun) add(s *Step) {
+	c.Steps = append(c.Steps, s)
+	if s.Status == Failure {
+		c.Result = Failure
+	}
+}
+
+func startStep(kind string, name string) *Step {
+	return &Step{Type: kind, Name: name, Status: Success, Started: time.Now().UTC()}
+}
+
+func (s *Step) finish(status string, message string) {
+	s.Duration = time.Since(s.Started).Seconds()
+	s.Status = status
+	s.Message = message
+}
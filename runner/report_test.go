@@ -0,0 +1,71 @@
+package runner
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReportWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewReport()
+	code := 0
+	r.Configs = append(r.Configs, &ConfigRun{Name: "config", Result: Success, Steps: []*Step{
+		{Type: "command", Name: "hello", Status: Success, Changed: true, ExitCode: &code},
+	}})
+	r.Finish(nil)
+
+	if err := r.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(r.Path(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read Report
+	if err := json.Unmarshal(b, &read); err != nil {
+		t.Fatal(err)
+	}
+	if read.RunId != r.RunId || read.Result != Success || *read.Configs[0].Steps[0].ExitCode != 0 {
+		t.Errorf("report did not survive the round trip: %s", b)
+	}
+}
+
+func TestPruneReports(t *testing.T) {
+	dir := t.TempDir()
+
+	old, recent := NewReport(), NewReport()
+	for _, r := range []*Report{old, recent} {
+		r.Finish(nil)
+		if err := r.Write(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	week := time.Now().Add(-7 * 24 * time.Hour)
+	if err := os.Chtimes(old.Path(dir), week, week); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := PruneReports(dir, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removed, []string{old.RunId}) {
+		t.Errorf("%v != [%v]", removed, old.RunId)
+	}
+	if _, err := os.Stat(old.Path(dir)); !os.IsNotExist(err) {
+		t.Error("old report should be removed")
+	}
+	if _, err := os.Stat(recent.Path(dir)); err != nil {
+		t.Error(err)
+	}
+}
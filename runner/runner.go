@@ -0,0 +1,136 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package runner applies the configs in CloudFormation::Init metadata to the
+// host, recording the outcome of every step in a Report
+package runner
+
+import (
+	"context"
+	"fmt"
+	"github.com/jdub/cfn-init-tools/config"
+	"github.com/jdub/cfn-init-tools/metadata"
+	"io"
+	"log"
+)
+
+// Runner applies configs, in the order given by the requested configSets
+type Runner struct {
+	Config config.Config
+	Report *Report
+
+	log     *log.Logger
+	backups []*Backup
+}
+
+// SectionError is returned when a config fails, identifying the section
+// (packages, files, commands, ...) responsible
+type SectionError struct {
+	Config  string
+	Section string
+	Err     error
+}
+
+func (e *SectionError) Error() string {
+	return fmt.Sprintf("Config %v: %v", e.Config, e.Err)
+}
+
+func (e *SectionError) Unwrap() error {
+	return e.Err
+}
+
+// New returns a Runner which logs to w
+func New(conf config.Config, w io.Writer) *Runner {
+	return &Runner{
+		Config: conf,
+		Report: NewReport(),
+		log:    log.New(w, "", log.LstdFlags),
+	}
+}
+
+// Run applies every config in configSets, stopping at the first failure or
+// when ctx is cancelled
+func (r *Runner) Run(ctx context.Context, m metadata.Metadata, configSets []string) error {
+	configs, err := m.Init.Resolve(configSets)
+	if err != nil {
+		return err
+	}
+
+	r.log.Printf("Running configSets: %v", configSets)
+	for _, name := range configs {
+		if err := r.runConfig(ctx, name, m.Init.Configs[name]); err != nil {
+			r.log.Printf("Error: %v", err)
+			return err
+		}
+	}
+	r.log.Printf("Completed configSets: %v", configSets)
+
+	return nil
+}
+
+func (r *Runner) runConfig(ctx context.Context, name string, c *metadata.Config) error {
+	run := &ConfigRun{Name: name, Result: Success, Steps: []*Step{}}
+	r.Report.Configs = append(r.Report.Configs, run)
+
+	r.log.Printf("Running config %v", name)
+
+	// Remote content is downloaded up front, so a failure changes nothing
+	staged, err := r.prefetch(ctx, run, c)
+	if err != nil {
+		return &SectionError{name, "files", err}
+	}
+
+	// Sections are applied in the same order as AWS cfn-init
+	if c.Packages != nil {
+		if err := r.packages(run, c.Packages); err != nil {
+			return &SectionError{name, "packages", err}
+		}
+	}
+	if len(c.Groups) > 0 {
+		return r.unsupported(run, name, "groups")
+	}
+	if len(c.Users) > 0 {
+		return r.unsupported(run, name, "users")
+	}
+	if err := r.sources(run, c.Sources, staged); err != nil {
+		return &SectionError{name, "sources", err}
+	}
+	if err := r.files(run, c.Files, staged); err != nil {
+		return &SectionError{name, "files", err}
+	}
+	if err := r.commands(ctx, run, c.Commands); err != nil {
+		return &SectionError{name, "commands", err}
+	}
+	if c.Services != nil && len(c.Services.SysVInit)+len(c.Services.Windows) > 0 {
+		return r.unsupported(run, name, "services")
+	}
+
+	return nil
+}
+
+// Fails a config with a section which can't be applied yet, rather than
+// reporting a bootstrap that didn't do what the metadata asked as a success.
+// Nothing that might depend on the section runs.
+func (r *Runner) unsupported(run *ConfigRun, config string, section string) error {
+	s := startStep(section, section)
+	s.finish(Failure, "not implemented")
+	run.add(s)
+	return &SectionError{config, section, fmt.Errorf("The %v section is not implemented", section)}
+}
@@ -0,0 +1,52 @@
+package runner
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+)
+
+func TestUnsupportedSections(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands are run with sh")
+	}
+
+	tests := []struct {
+		json, section string
+		steps         int
+	}{
+		// Commands which may depend on the user don't run
+		{`{"AWS::CloudFormation::Init": {"config": {
+			"users": {"pants": {}},
+			"commands": {"hello": {"command": "true"}}
+		}}}`, "users", 1},
+		{`{"AWS::CloudFormation::Init": {"config": {
+			"groups": {"pants": {}}
+		}}}`, "groups", 1},
+		{`{"AWS::CloudFormation::Init": {"config": {
+			"commands": {"hello": {"command": "true"}},
+			"services": {"sysvinit": {"pants": {"enabled": "true"}}}
+		}}}`, "services", 2},
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	for _, test := range tests {
+		r, err := run(t, test.json)
+		var section *SectionError
+		if !errors.As(err, &section) || section.Section != test.section {
+			t.Errorf("%v: unsupported section should be an error: %v", test.section, err)
+			continue
+		}
+
+		r.Report.Finish(err)
+		steps := r.Report.Configs[0].Steps
+		if r.Report.Result != Failure || len(steps) != test.steps || steps[len(steps)-1].Status != Failure {
+			t.Errorf("%v: unexpected report: %v %+v", test.section, r.Report.Result, steps)
+		}
+	}
+
+	// An empty services section asks for nothing
+	if _, err := run(t, `{"AWS::CloudFormation::Init": {"config": {"services": {}}}}`); err != nil {
+		t.Error(err)
+	}
+}
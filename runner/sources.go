@@ -0,0 +1,268 @@
+// Copyright © 2016 Jeff Waugh <jdub@bethesignal.org>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package runner
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Sources are extracted in alphabetical order by target directory
+func (r *Runner) sources(run *ConfigRun, sources map[string]string, staged map[string]string) error {
+	dirs := sortedKeys(sources)
+
+	for _, dir := range dirs {
+		s := startStep("source", dir)
+		url, _ := splitChecksum(sources[dir])
+		r.log.Printf("Extracting %v to %v", url, dir)
+
+		path, ok := staged[url]
+		err := fmt.Errorf("%v was not downloaded", url)
+		if ok {
+			err = extract(path, dir)
+		}
+		if err != nil {
+			s.finish(Failure, err.Error())
+			run.add(s)
+			return fmt.Errorf("Could not extract %v to %v: %v", url, dir, err)
+		}
+		s.Changed = true
+		s.finish(Success, "")
+		run.add(s)
+	}
+	return nil
+}
+
+// Extracts a zip, tar, or gzip or bzip2 compressed tar archive into dir,
+// recognising the format by its content rather than its name
+func extract(archive string, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	b := bufio.NewReader(f)
+	magic, _ := b.Peek(4)
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		return extractZip(f, info.Size(), dir)
+
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		z, err := gzip.NewReader(b)
+		if err != nil {
+			return err
+		}
+		defer z.Close()
+		return extractTar(z, dir)
+
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return extractTar(bzip2.NewReader(b), dir)
+	}
+
+	return extractTar(b, dir)
+}
+
+// Returns where an archive entry belongs in dir, refusing any that escape it
+func entryPath(dir string, name string) (string, error) {
+	path := filepath.Join(dir, name)
+	if !within(dir, path) {
+		return "", fmt.Errorf("Archive entry %v is outside the target directory", name)
+	}
+	return path, nil
+}
+
+func within(dir string, path string) bool {
+	dir = filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
+
+// Refuses to create anything through a symlink below dir, such as one
+// extracted earlier from the same archive, checking each existing component
+// of path
+func noSymlinks(dir string, path string) error {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == "." {
+		return err
+	}
+
+	p := filepath.Clean(dir)
+	for _, part := range strings.Split(rel, string(os.PathSeparator)) {
+		p = filepath.Join(p, part)
+		info, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("Refusing to extract through symlink %v", p)
+		}
+	}
+	return nil
+}
+
+// Prepares to replace path with a file or symlink: its parents must not be
+// symlinks, and an existing symlink is removed rather than followed
+func prepareEntry(dir string, path string) error {
+	if err := noSymlinks(dir, filepath.Dir(path)); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return os.Remove(path)
+	}
+	return nil
+}
+
+func makeDir(dir string, path string, mode os.FileMode) error {
+	if err := noSymlinks(dir, path); err != nil {
+		return err
+	}
+	return os.MkdirAll(path, mode.Perm()|0700)
+}
+
+// Links path to target, which must be relative and stay within dir
+func makeSymlink(dir string, path string, target string) error {
+	if filepath.IsAbs(target) || strings.HasPrefix(target, "/") || !within(dir, filepath.Join(filepath.Dir(path), target)) {
+		return fmt.Errorf("Archive symlink %v -> %v points outside the target directory", path, target)
+	}
+	if err := prepareEntry(dir, path); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(target, path)
+}
+
+func extractTar(r io.Reader, dir string) error {
+	t := tar.NewReader(r)
+	for {
+		h, err := t.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		path, err := entryPath(dir, h.Name)
+		if err != nil {
+			return err
+		}
+
+		switch h.Typeflag {
+		case tar.TypeDir:
+			err = makeDir(dir, path, h.FileInfo().Mode())
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeEntry(dir, path, t, h.FileInfo().Mode())
+		case tar.TypeSymlink:
+			err = makeSymlink(dir, path, h.Linkname)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(r io.ReaderAt, size int64, dir string) error {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	// Directories first, in case their entries are out of order
+	files := z.File
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].FileInfo().IsDir() && !files[j].FileInfo().IsDir()
+	})
+
+	for _, f := range files {
+		path, err := entryPath(dir, f.Name)
+		if err != nil {
+			return err
+		}
+
+		if f.FileInfo().IsDir() {
+			if err := makeDir(dir, path, f.Mode()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		if f.Mode()&os.ModeSymlink != 0 {
+			// A zip symlink's content is its target
+			var target []byte
+			if target, err = ioutil.ReadAll(io.LimitReader(rc, 4096)); err == nil {
+				err = makeSymlink(dir, path, string(target))
+			}
+		} else {
+			err = writeEntry(dir, path, rc, f.Mode())
+		}
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeEntry(dir string, path string, r io.Reader, mode os.FileMode) error {
+	if err := prepareEntry(dir, path); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,148 @@
+package runner
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func tarGz(t *testing.T, files map[string]string) []byte {
+	var b bytes.Buffer
+	z := gzip.NewWriter(&b)
+	w := tar.NewWriter(z)
+	for name, content := range files {
+		w.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		w.Write([]byte(content))
+	}
+	w.Close()
+	z.Close()
+	return b.Bytes()
+}
+
+func zipped(t *testing.T, files map[string]string) []byte {
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for name, content := range files {
+		f, _ := w.Create(name)
+		f.Write([]byte(content))
+	}
+	w.Close()
+	return b.Bytes()
+}
+
+func TestExtract(t *testing.T) {
+	archives := map[string][]byte{
+		"tgz": tarGz(t, map[string]string{"dir/file": "pants"}),
+		"zip": zipped(t, map[string]string{"dir/file": "pants"}),
+	}
+
+	for name, archive := range archives {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "archive")
+		if err := ioutil.WriteFile(path, archive, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		target := filepath.Join(dir, "target")
+		if err := extract(path, target); err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if b, err := ioutil.ReadFile(filepath.Join(target, "dir/file")); err != nil || string(b) != "pants" {
+			t.Errorf("%v: %q %v", name, b, err)
+		}
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	dir := t.TempDir()
+	path := filepath.Join(dir, "archive")
+	if err := ioutil.WriteFile(path, tarGz(t, map[string]string{"../escape": "pants"}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := extract(path, filepath.Join(dir, "target")); err == nil {
+		t.Error("entries outside the target directory should be an error")
+	}
+}
+
+// An archive entry, in order
+type entry struct {
+	name, content, link string
+}
+
+func tarball(t *testing.T, entries []entry) []byte {
+	var b bytes.Buffer
+	w := tar.NewWriter(&b)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.content)), Typeflag: tar.TypeReg}
+		if e.link != "" {
+			h = &tar.Header{Name: e.name, Mode: 0777, Linkname: e.link, Typeflag: tar.TypeSymlink}
+		}
+		if err := w.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(e.content))
+	}
+	w.Close()
+	return b.Bytes()
+}
+
+func zipLink(t *testing.T, name string, target string) []byte {
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	h := &zip.FileHeader{Name: name}
+	h.SetMode(os.ModeSymlink | 0777)
+	f, err := w.CreateHeader(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(target))
+	w.Close()
+	return b.Bytes()
+}
+
+func TestExtractSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+
+	outside := t.TempDir()
+
+	// A link within the target directory is fine
+	dir := t.TempDir()
+	path := filepath.Join(dir, "archive")
+	ioutil.WriteFile(path, tarball(t, []entry{{name: "file", content: "pants"}, {name: "link", link: "file"}}), 0600)
+	if err := extract(path, filepath.Join(dir, "target")); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "target", "link")); err != nil || string(b) != "pants" {
+		t.Errorf("%q %v", b, err)
+	}
+
+	// No, Mr. Bond, I expect you to die!
+	archives := map[string][]byte{
+		"absolute link":         tarball(t, []entry{{name: "link", link: outside}}),
+		"relative link":         tarball(t, []entry{{name: "dir/link", link: "../../" + filepath.Base(outside)}}),
+		"write through link":    tarball(t, []entry{{name: "dir/file", content: "pants"}, {name: "link", link: "dir"}, {name: "link/evil", content: "pants"}}),
+		"zip link":              zipLink(t, "link", outside),
+		"absolute link, nested": tarball(t, []entry{{name: "link", link: outside}, {name: "link/evil", content: "pants"}}),
+	}
+	for name, archive := range archives {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "archive")
+		if err := ioutil.WriteFile(path, archive, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := extract(path, filepath.Join(dir, "target")); err == nil {
+			t.Errorf("%v should be an error", name)
+		}
+	}
+
+	if files, _ := ioutil.ReadDir(outside); len(files) != 0 {
+		t.Errorf("files were written outside the target directory: %v", files[0].Name())
+	}
+}